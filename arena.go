@@ -0,0 +1,82 @@
+package bencode
+
+// arenaChunkSize is the number of elements in each chunk an Arena allocates.
+// Requests for more than a quarter of a chunk are allocated on their own, so
+// that a large string does not waste the rest of a chunk.
+const arenaChunkSize = 64 << 10
+
+// An Arena holds the memory behind the lists, and the byte slices of UseBytes,
+// of values decoded by a Decoder that uses it, set with UseArena. Values are
+// carved out of large chunks, rather than each being allocated separately,
+// which reduces the work of the garbage collector when decoding many small
+// values, such as the torrents or announces a busy tracker handles. Strings,
+// dictionaries, and integers stored in an any are still allocated as usual,
+// since a Go string must never change.
+//
+// The []any lists and []byte strings decoded into an any are only valid until
+// Reset is called. Reset reuses the memory for later values, so such a value
+// decoded before it will change underfoot. Anything that must outlive the
+// arena has to be copied first, for example with slices.Clone. Values decoded
+// into other Go types, such as a []byte or []string field, are allocated as
+// usual and remain valid.
+//
+// The zero value is an empty arena ready to use. An Arena must not be used by
+// more than one Decoder at a time.
+type Arena struct {
+	bytes arenaSlab[byte]
+	lists arenaSlab[any]
+
+	// stack holds the elements of the lists being decoded, which are copied
+	// into the arena once their length is known.
+	stack []any
+}
+
+// Reset makes all of the memory of a available for reuse, invalidating every
+// value decoded with it so far.
+func (a *Arena) Reset() {
+	a.bytes.reset(false)
+	a.lists.reset(true)
+}
+
+// arenaSlab is a bump allocator of slices of T, backed by chunks that are kept
+// for reuse after a reset.
+type arenaSlab[T any] struct {
+	chunks [][]T
+	cur    int // the index of the chunk being allocated from
+	off    int // the number of elements of chunks[cur] in use
+}
+
+// alloc returns a slice of n elements. Its capacity is n, so appending to it
+// cannot overwrite memory handed out later.
+func (s *arenaSlab[T]) alloc(n int) []T {
+	if n > arenaChunkSize/4 {
+		return make([]T, n)
+	}
+	if s.cur == len(s.chunks) || s.off+n > len(s.chunks[s.cur]) {
+		if s.cur < len(s.chunks) {
+			s.cur++
+		}
+		s.off = 0
+		if s.cur == len(s.chunks) {
+			s.chunks = append(s.chunks, make([]T, arenaChunkSize))
+		}
+	}
+	p := s.chunks[s.cur][s.off : s.off+n : s.off+n]
+	s.off += n
+	return p
+}
+
+// reset makes the chunks of s available for reuse. With zero set, the memory
+// in use is cleared first, so that it does not keep anything reachable.
+func (s *arenaSlab[T]) reset(zero bool) {
+	if zero {
+		for i := 0; i < s.cur && i < len(s.chunks); i++ {
+			clear(s.chunks[i])
+		}
+		if s.cur < len(s.chunks) {
+			clear(s.chunks[s.cur][:s.off])
+		}
+	}
+	s.cur = 0
+	s.off = 0
+}
@@ -0,0 +1,208 @@
+package bencode
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecoderUseArena(t *testing.T) {
+	inputs := []string{
+		multiTrackerTorrent,
+		"l4:spami42eli1eed3:foo3:baree",
+		"lle0:dee",
+		"l" + strconv.Itoa(arenaChunkSize) + ":" + strings.Repeat("y", arenaChunkSize) + "e",
+	}
+
+	var a Arena
+	for _, in := range inputs {
+		var want any
+		if err := Unmarshal([]byte(in), &want); err != nil {
+			t.Fatalf("Unmarshal(%.20q) error = %v", in, err)
+		}
+
+		d := NewDecoder(strings.NewReader(in))
+		d.UseArena(&a)
+		var got any
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode(%.20q) error = %v", in, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Decode(%.20q) got = %v, want %v", in, got, want)
+		}
+	}
+
+	var got Torrent
+	d := NewDecoder(strings.NewReader(multiTrackerTorrent))
+	d.UseArena(&a)
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	var want Torrent
+	if err := Unmarshal([]byte(multiTrackerTorrent), &want); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestArenaListsDoNotOverlap(t *testing.T) {
+	var a Arena
+	d := NewDecoder(strings.NewReader("ll1:ael1:bee"))
+	d.UseArena(&a)
+	var got []any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	first := got[0].([]any)
+	_ = append(first, "clobbered")
+	if second := got[1].([]any); second[0] != "b" {
+		t.Errorf("appending to one list changed the next: %v", second)
+	}
+}
+
+func TestArenaStringsOutliveReset(t *testing.T) {
+	// Strings, including map keys and struct fields, never share arena
+	// memory, so they are unchanged when it is reused.
+	var a Arena
+	d := NewDecoder(strings.NewReader("d3:key5:valueed3:xxx5:yyyyye"))
+	d.UseArena(&a)
+	var generic map[string]any
+	if err := d.Decode(&generic); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	a.Reset()
+	var next any
+	if err := d.Decode(&next); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if want := map[string]any{"key": "value"}; !reflect.DeepEqual(generic, want) {
+		t.Errorf("Decode() got = %v after Reset, want %v", generic, want)
+	}
+
+	d = NewDecoder(strings.NewReader("d4:name5:firste" + "d4:name5:xxxxxe"))
+	d.UseArena(&a)
+	d.UseBytes()
+	var field struct {
+		Name string `bencode:"name"`
+	}
+	if err := d.Decode(&field); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	a.Reset()
+	if err := d.Decode(&next); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if field.Name != "first" {
+		t.Errorf("Decode() got Name = %q after Reset, want %q", field.Name, "first")
+	}
+}
+
+func TestArenaReset(t *testing.T) {
+	var a Arena
+	for i := range 1000 {
+		d := NewDecoder(strings.NewReader(multiTrackerTorrent))
+		d.UseArena(&a)
+		d.UseBytes()
+		var got any
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if i == 999 {
+			break
+		}
+		a.Reset()
+	}
+	if n := len(a.bytes.chunks); n != 1 {
+		t.Errorf("arena holds %d byte chunks after reuse, want 1", n)
+	}
+	if n := len(a.lists.chunks); n != 1 {
+		t.Errorf("arena holds %d list chunks after reuse, want 1", n)
+	}
+
+	// Reset drops the references held by decoded lists.
+	a.Reset()
+	for _, v := range a.lists.chunks[0][:16] {
+		if v != nil {
+			t.Fatalf("arena list memory holds %v after Reset", v)
+		}
+	}
+}
+
+func TestArenaAllocs(t *testing.T) {
+	data := announceList(200)
+	var a Arena
+	r := bytes.NewReader(nil)
+	decode := func(arena *Arena) func() {
+		return func() {
+			r.Reset(data)
+			d := NewDecoder(r)
+			d.UseBytes()
+			d.InternKeys()
+			if arena != nil {
+				arena.Reset()
+				d.UseArena(arena)
+			}
+			var v any
+			if err := d.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// The arena holds the two string values of each of the 200 dictionaries,
+	// which UseBytes decodes as byte slices, and the list itself. Keys are
+	// interned, and the dictionaries are allocated either way.
+	without := testing.AllocsPerRun(20, decode(nil))
+	with := testing.AllocsPerRun(20, decode(&a))
+	if without-with < 2*200 {
+		t.Errorf("Decode() with an arena made %v allocations, without %v", with, without)
+	}
+}
+
+// announceList returns a list of n dictionaries of short strings, as in a
+// tracker's peer list.
+func announceList(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("l")
+	for i := range n {
+		ip := "10.0.0." + strconv.Itoa(i%256)
+		buf.WriteString("d2:ip" + strconv.Itoa(len(ip)) + ":" + ip + "7:peer id20:-XX0001-abcdefghijkl4:porti6881ee")
+	}
+	buf.WriteString("e")
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeGeneric(b *testing.B) {
+	data := announceList(200)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	r := bytes.NewReader(nil)
+	for range b.N {
+		r.Reset(data)
+		var v any
+		if err := NewDecoder(r).Decode(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeGenericArena(b *testing.B) {
+	data := announceList(200)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	r := bytes.NewReader(nil)
+	var a Arena
+	for range b.N {
+		a.Reset()
+		r.Reset(data)
+		d := NewDecoder(r)
+		d.UseArena(&a)
+		var v any
+		if err := d.Decode(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,40 +1,761 @@
 package bencode
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"sync"
 )
 
 // Unmarshal decodes the given Bencoded data into the given value.
+// If v is nil or not a pointer, Unmarshal returns an InvalidUnmarshalError.
+//
+// A value decoded into an empty interface, including the elements of a []any
+// or map[string]any, is stored in generic form: a string, an int64 or
+// *big.Int, a []any, or a map[string]any. An interface with methods can only
+// hold such a value if it implements them, so decoding into one, such as an
+// element of a []fmt.Stringer, returns an *UnmarshalTypeError.
+//
+// Integers decoded into an empty interface are int64, or *big.Int if they do
+// not fit. Integers decoded into a Go integer type must fit that type's width,
+// which for int and uint is that of the platform, so a value that fits an int
+// on a 64-bit platform can be an *UnmarshalTypeError on a 32-bit one. Use
+// int64 or uint64 fields where values may exceed 32 bits, such as file sizes.
+//
+// A struct field whose tag has the "required" option, as in
+// `bencode:"info,required"`, must have its key present in the dictionary, or
+// Unmarshal returns an error naming the missing key. Other fields are left
+// unchanged when their key is absent.
+//
+// Empty input holds no value, so Unmarshal returns io.ErrUnexpectedEOF for it,
+// as it does for input that ends partway through a value.
 func Unmarshal(data []byte, v any) error {
-	return NewDecoder(bytes.NewReader(data)).Decode(v)
+	_, err := Decode(data, v)
+	return err
+}
+
+// Decode decodes the first Bencode value in data into v and returns the number
+// of bytes it occupied, so that framed protocols can continue parsing from
+// data[n:].
+//
+// If an error occurs, n is the number of bytes consumed before the error.
+// Empty data returns io.ErrUnexpectedEOF, as for Unmarshal.
+func Decode(data []byte, v any) (n int, err error) {
+	p := getDecoder(data)
+	defer p.release()
+	err = p.d.Decode(v)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return int(p.r.offset), err
+}
+
+// pooledDecoder is a Decoder for a byte slice, together with the reader and
+// buffer behind it, that is kept in decoderPool for reuse.
+type pooledDecoder struct {
+	d   Decoder
+	r   reader
+	src bytes.Reader
+}
+
+// decoderPool holds decoders for reuse by Unmarshal and Decode, so that
+// decoding a small value does not allocate a new bufio.Reader each time.
+var decoderPool = sync.Pool{
+	New: func() any {
+		p := new(pooledDecoder)
+		p.r.r = bufio.NewReader(&p.src)
+		return p
+	},
+}
+
+// getDecoder returns a pooled decoder reading from data, with every option at
+// its default. It must be returned with release once decoding is done.
+func getDecoder(data []byte) *pooledDecoder {
+	p := decoderPool.Get().(*pooledDecoder)
+	p.src.Reset(data)
+	p.r.r.Reset(&p.src)
+	p.r = reader{r: p.r.r, src: &p.src, maxIntDigits: defaultMaxIntDigits, data: data}
+	p.d = Decoder{r: &p.r, tokens: p.d.tokens[:0], path: p.d.path[:0], steps: p.d.steps[:0], tagName: defaultTagName}
+	return p
+}
+
+// release drops p's reference to its input and returns it to decoderPool.
+func (p *pooledDecoder) release() {
+	p.src.Reset(nil)
+	p.r.data = nil
+	p.r.r.Reset(&p.src)
+	decoderPool.Put(p)
+}
+
+// RawMessage is a raw encoded Bencode value.
+// It can be used to delay decoding part of a message, or to embed an
+// already-encoded value when marshaling.
+//
+// When unmarshaling, a RawMessage receives a copy of the value's bytes exactly
+// as they were in the input, so that a hash of them, such as a torrent's info
+// hash, matches the original even if it was not in canonical form. A value
+// changed by a decode hook, or built from several entries by the Collect
+// duplicate key policy, has no such bytes and is re-encoded instead.
+type RawMessage []byte
+
+// StringReader is a Bencode string whose contents are streamed from R when
+// marshaled, rather than held in memory. This suits large values such as a
+// torrent's pieces that are computed on the fly.
+//
+// Exactly Len bytes are copied from R, and encoding fails if R ends early.
+// A StringReader can only be marshaled once, since doing so consumes R.
+type StringReader struct {
+	Len int64
+	R   io.Reader
 }
 
 // A Decoder reads and decodes Bencode values from an input stream.
 type Decoder struct {
 	r *reader
+
+	// tokens holds the lists and dictionaries opened by Token.
+	tokens []container
+
+	// path holds the dictionary keys leading to the value being unmarshaled,
+	// for error messages.
+	path []string
+
+	// steps holds the dictionary keys, as they appear in the input, and the
+	// list indices leading to the value being unmarshaled, so that the offset
+	// of a value that cannot be unmarshaled can be found in input.
+	steps []pathStep
+
+	// input is the encoded form of the whole value being unmarshaled, and
+	// inputOffset the offset it starts at. input is nil if it was not kept.
+	input       []byte
+	inputOffset int64
+
+	// caseInsensitive matches dictionary keys to struct fields regardless of case.
+	caseInsensitive bool
+
+	// trimStrings removes leading and trailing ASCII whitespace from values
+	// decoded into Go strings.
+	trimStrings bool
+
+	// tagName is the struct tag key that field keys and options are read from.
+	tagName string
+
+	// typeKey is the dictionary key naming the concrete type to decode a
+	// dictionary into when the target is an interface, and types maps those
+	// names to factories registered with RegisterType.
+	typeKey string
+	types   map[string]func() any
+
+	// hook, if set, transforms values before they are stored.
+	hook DecodeHookFunc
+
+	// raw is the encoded form, as it was in the input, of the value being
+	// unmarshaled, kept when the target can hold a RawMessage. It is nil if
+	// the target cannot, or if the value has no single encoded form.
+	raw []byte
 }
 
 // NewDecoder returns a new decoder that reads from r.
 //
 // The decoder introduces its own buffering and may read data from r beyond the Bencode values requested.
+// Decoded strings, byte slices and RawMessages are always copied out of that
+// buffer, so they remain valid and unchanged as further values are read.
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r: newReader(r)}
+	return &Decoder{r: newReader(r), tagName: defaultTagName}
+}
+
+// NewDecoderSize returns a new decoder that reads from r through a buffer of
+// at least size bytes, where NewDecoder uses 4096. A larger buffer means fewer
+// reads from r, which helps throughput on inputs with large strings, such as
+// the pieces of a big torrent. Sizes below 64 are raised to 64.
+//
+// If r is a *bufio.Reader with a buffer of at least size bytes, it is used
+// directly.
+func NewDecoderSize(r io.Reader, size int) *Decoder {
+	return &Decoder{r: newReaderSize(r, max(size, minBufferSize)), tagName: defaultTagName}
+}
+
+// Reset makes the Decoder read from r, discarding any input it has buffered
+// from its previous source and any partly read lists and dictionaries, so
+// that a single Decoder can be reused for a series of connections or files.
+// Every option set on the Decoder is kept, and its buffer is reused where
+// possible.
+func (d *Decoder) Reset(r io.Reader) {
+	d.r.reset(r)
+	d.tokens = d.tokens[:0]
+	d.path = d.path[:0]
+	d.steps = d.steps[:0]
+}
+
+// ErrStop can be returned by a DecodeList or DecodeDict callback to stop
+// decoding early. They then return nil rather than the error.
+var ErrStop = errors.New("bencode: stop decoding")
+
+// DecodeList reads the next Bencode value from its input, which must be a
+// list, and calls fn with each element as it is decoded. Elements are passed
+// in the same form as when decoding into an any, and the list as a whole is
+// never held in memory, which suits very large lists such as peer lists.
+//
+// If fn returns an error, decoding stops and DecodeList returns that error,
+// or nil if it is or wraps ErrStop. The rest of the list is then left unread.
+//
+// Like Decode, DecodeList returns io.EOF at the end of the input, so that a
+// stream of lists can be read in a loop. If the next value is not a list, it
+// returns an error without consuming any of it.
+func (d *Decoder) DecodeList(fn func(elem any) error) error {
+	d.r.recovered = nil
+	d.r.elements = 0
+	err := d.r.decodeListFunc(fn)
+	if errors.Is(err, ErrStop) {
+		return d.recoveredError()
+	}
+	if err != nil {
+		return err
+	}
+	d.tokenAdvance()
+	return d.recoveredError()
+}
+
+// DecodeDict reads the next Bencode value from its input, which must be a
+// dictionary, and calls fn with each key and value as they are decoded.
+// Values are passed in the same form as when decoding into an any, and the
+// dictionary as a whole is never held in memory, which suits very large
+// dictionaries such as scrape responses covering many torrents.
+//
+// Keys are passed in input order, folded if FoldMapKeys is set. Since no
+// record is kept of the keys already seen, a duplicate key is passed each
+// time it appears, whatever the duplicate key policy.
+//
+// If fn returns an error, decoding stops and DecodeDict returns that error,
+// or nil if it is or wraps ErrStop. The rest of the dictionary is then left
+// unread.
+//
+// Like Decode, DecodeDict returns io.EOF at the end of the input. If the next
+// value is not a dictionary, it returns an error without consuming any of it.
+func (d *Decoder) DecodeDict(fn func(key string, value any) error) error {
+	d.r.recovered = nil
+	d.r.elements = 0
+	err := d.r.decodeDictFunc(func(key string, _ int64, value any) error {
+		return fn(key, value)
+	})
+	if errors.Is(err, ErrStop) {
+		return d.recoveredError()
+	}
+	if err != nil {
+		return err
+	}
+	d.tokenAdvance()
+	return d.recoveredError()
+}
+
+// RequireCanonical causes the Decoder to return an error when the input is not
+// in canonical form, meaning it is not byte-for-byte what Marshal would produce.
+// Dictionary keys must be in strictly ascending order, and integers and string
+// lengths must be written without leading zeros, a plus sign, or a negative zero.
+//
+// Canonical form matters wherever encoded data is hashed, such as a torrent's
+// info dictionary, since otherwise two different encodings can represent the
+// same value.
+func (d *Decoder) RequireCanonical() {
+	d.r.canonical = true
+}
+
+// DuplicateKeyPolicy controls how a Decoder handles a dictionary key that
+// appears more than once. Duplicate keys are invalid Bencode, but are found in
+// data produced by broken encoders.
+type DuplicateKeyPolicy int
+
+const (
+	// KeepLast keeps the value of the last occurrence of a key.
+	// It is the default policy.
+	KeepLast DuplicateKeyPolicy = iota
+
+	// Collect gathers the values of every occurrence of a duplicated key into
+	// a []any, in input order. Keys that appear once are left as they are.
+	Collect
+
+	// KeepFirst keeps the value of the first occurrence of a key. The values
+	// of later occurrences are still read, and must be well formed, but are
+	// discarded.
+	KeepFirst
+
+	// Reject fails decoding with an error naming the duplicated key and its
+	// offset. Duplicate keys can be a sign of tampering, where two parsers
+	// that resolve them differently see different values, so security
+	// sensitive code should prefer this policy. In lenient mode the error is
+	// recorded and the first value is kept.
+	Reject
+)
+
+// SetDuplicateKeyPolicy sets how the Decoder handles duplicate dictionary keys.
+func (d *Decoder) SetDuplicateKeyPolicy(p DuplicateKeyPolicy) {
+	d.r.duplicates = p
+}
+
+// SetMaxLengthRatio limits the declared length of any string to ratio times
+// the number of input bytes remaining after its length prefix. A declared
+// length that could never be satisfied is then rejected as soon as it is read.
+// A ratio of 1 rejects every string longer than the rest of the input.
+//
+// The limit only applies when the size of the input is known, which is the case
+// for a Decoder reading from a *bytes.Reader or *strings.Reader. A ratio of 0,
+// the default, disables the check.
+func (d *Decoder) SetMaxLengthRatio(ratio float64) {
+	d.r.maxLengthRatio = ratio
+}
+
+// SetMaxIntDigits limits the number of digits an integer may have. Without a
+// limit, an integer with an enormous run of digits and no terminating 'e' is
+// read into memory in full before it can be rejected.
+//
+// The limit applies to every integer in the input, whatever it is decoded
+// into, counting any leading zeros. It defaults to 65536 digits, which is
+// generous enough for any real integer. A limit of 0 disables the check.
+func (d *Decoder) SetMaxIntDigits(n int) {
+	d.r.maxIntDigits = n
+}
+
+// SetMaxElements limits the number of values a single call to Decode may
+// decode, counting every string, integer, list and dictionary however deeply
+// nested, but not dictionary keys. This bounds the memory a small input can
+// make the Decoder use, such as a flat list of millions of one-byte integers.
+// For DecodeList, the values within the list count toward the limit.
+//
+// A limit of 0, the default, disables the check.
+func (d *Decoder) SetMaxElements(n int) {
+	d.r.maxElements = n
+}
+
+// InternKeys causes the Decoder to share one copy of each dictionary key among
+// all the values it decodes, rather than allocating every occurrence of a key
+// separately. Torrents and tracker responses repeat the same few keys many
+// times, so this saves allocations and memory, at the cost of a lookup per
+// key. The keys are kept for the life of the Decoder, up to a fixed number of
+// distinct keys, with long keys never shared.
+func (d *Decoder) InternKeys() {
+	if d.r.keys == nil {
+		d.r.keys = make(map[string]string)
+		d.r.keyBuf = make([]byte, maxInternedKeyLen)
+	}
+}
+
+// FoldMapKeys causes the Decoder to lowercase the ASCII letters of every
+// dictionary key, so that keys can be looked up without regard to case.
+//
+// This is lossy: the original case of keys is discarded, and keys that differ
+// only in case collide. Collisions are handled like any other duplicate key,
+// according to the policy set with SetDuplicateKeyPolicy.
+func (d *Decoder) FoldMapKeys() {
+	d.r.foldKeys = true
+}
+
+// CaseInsensitive causes the Decoder to match dictionary keys to struct fields
+// without regard to case, as encoding/json does, when no key matches exactly.
+// This applies to keys taken from tags as well as field names.
+func (d *Decoder) CaseInsensitive() {
+	d.caseInsensitive = true
+}
+
+// TrimStringFields causes the Decoder to remove leading and trailing ASCII
+// whitespace from values decoded into Go strings, such as an announce URL with
+// a stray trailing space in a hand-edited torrent.
+//
+// Bencode strings are binary, so this is off by default. It applies only to
+// Go values of kind string; byte slices, RawMessage and values decoded into
+// an interface are left untouched.
+func (d *Decoder) TrimStringFields() {
+	d.trimStrings = true
+}
+
+// UseNumber causes the Decoder to decode integers into an any as a Number
+// rather than an int64 or *big.Int, keeping the digits as written until the
+// caller chooses a type. Tokens returned by Token are affected in the same way.
+// Integers decoded into other Go types are unaffected.
+func (d *Decoder) UseNumber() {
+	d.r.useNumber = true
+}
+
+// UseInt causes the Decoder to decode integers into an any as an int rather
+// than an int64, which is handier to work with, and leaves those that do not
+// fit in an int as a *big.Int. Tokens returned by Token are affected in the
+// same way. Integers decoded into other Go types are unaffected, and UseNumber
+// takes precedence.
+//
+// The size of an int depends on the platform, so on a 32-bit one integers
+// beyond 32 bits, such as the sizes of files over 2GB, are a *big.Int. Code
+// that must behave the same everywhere should not use this option.
+func (d *Decoder) UseInt() {
+	d.r.useInt = true
+}
+
+// UseBytes causes the Decoder to decode strings into an any as a []byte rather
+// than a string, so that binary values such as the pieces of a torrent are not
+// mistaken for text. Dictionary keys are still strings, since they are map
+// keys, and strings decoded into other Go types, and tokens returned by Token,
+// are unaffected.
+//
+// Every string becomes a []byte, whatever its contents, rather than guessing
+// which are binary, so code walking the result must expect []byte for text
+// too, and cannot compare values with == or use them as map keys.
+func (d *Decoder) UseBytes() {
+	d.r.useBytes = true
+}
+
+// UseOrderedDict causes the Decoder to decode dictionaries into an any as an
+// *OrderedDict rather than a map[string]any, keeping their keys in input
+// order. Decoding into an OrderedDict also keeps the input order, rather than
+// sorting the keys. Dictionaries decoded into other Go types are unaffected.
+//
+// Marshal still writes the keys of an OrderedDict in sorted order, so a value
+// re-encodes to the original bytes only if the input was canonical. The
+// Sorted method of each OrderedDict reports whether that was so.
+func (d *Decoder) UseOrderedDict() {
+	d.r.orderedDicts = true
+}
+
+// RequireUTF8 causes the Decoder to return an error when a dictionary key is
+// not valid UTF-8. Keys are names, so invalid UTF-8 in one usually means the
+// input is corrupt, and such keys cannot be displayed faithfully.
+//
+// String values are binary, such as a torrent's pieces, so they are never
+// checked.
+func (d *Decoder) RequireUTF8() {
+	d.r.requireUTF8 = true
+}
+
+// Lenient causes the Decoder to skip malformed elements of lists and entries
+// of dictionaries rather than stopping at the first one, which helps salvage
+// what it can from partially corrupt data such as old torrent archives.
+//
+// After a malformed element, decoding resumes at the next byte that can start
+// a value or end the enclosing list or dictionary. This is a best effort:
+// corruption can hide the structure of the input, so values around a skipped
+// one may be lost or misread as well. If anything was skipped, Decode and
+// DecodeList return a *RecoveredError listing the errors after decoding the
+// rest, and v holds what could be decoded. Input that ends early is never
+// recovered from.
+func (d *Decoder) Lenient() {
+	d.r.lenient = true
+}
+
+// UseArena causes the Decoder to take the memory for lists, and for the byte
+// slices of UseBytes, decoded into an any from a, rather than allocating each
+// separately, until it is called again with nil. Those values are only valid
+// until a is reset; see Arena. Strings are always allocated separately.
+func (d *Decoder) UseArena(a *Arena) {
+	d.r.arena = a
+}
+
+// SetTagName sets the struct tag key the Decoder reads field keys and options
+// from, in place of the default "bencode". This suits codebases that share one
+// tag between formats. Fields without a tag of that name use their Go name.
+func (d *Decoder) SetTagName(name string) {
+	d.tagName = name
+}
+
+// SetTypeKey enables polymorphic decoding of dictionaries into interfaces. When
+// a dictionary is decoded into a nil interface value, such as an element of a
+// []Message for some interface Message, and its key named key holds a string
+// registered with RegisterType, the dictionary is decoded into a new value
+// from that type's factory instead of a map[string]any.
+//
+// Dictionaries without the key, or naming an unregistered type, are decoded as
+// usual. An empty key, the default, disables polymorphic decoding.
+func (d *Decoder) SetTypeKey(key string) {
+	d.typeKey = key
+}
+
+// RegisterType registers factory as the source of values for dictionaries
+// whose type key, set with SetTypeKey, is name. The factory must return a
+// non-nil pointer, such as new(Announce), which the dictionary is decoded into
+// and which is then stored in the interface.
+func (d *Decoder) RegisterType(name string, factory func() any) {
+	if d.types == nil {
+		d.types = make(map[string]func() any)
+	}
+	d.types[name] = factory
+}
+
+// A DecodeHookFunc transforms a decoded value before it is stored in a Go
+// value of type target. The raw value is in the form decoding into an any
+// produces, such as a string, int64, *big.Int, []any or map[string]any. The
+// hook returns either a value of exactly type target, which is stored as it
+// is, or a value in that same raw form to be converted as usual in place of
+// raw. Returning raw unchanged leaves decoding unaffected, and returning nil
+// leaves the target unchanged.
+type DecodeHookFunc func(target reflect.Type, raw any) (any, error)
+
+// SetDecodeHook sets a hook that the Decoder calls with every value it stores
+// while unmarshaling, including lists and dictionaries before their elements,
+// such as to decode a base32 string into bytes or map an integer onto an
+// enumeration. An error from the hook stops decoding and is returned, wrapped
+// with the key it occurred at. A nil hook, the default, disables it.
+func (d *Decoder) SetDecodeHook(hook DecodeHookFunc) {
+	d.hook = hook
 }
 
 // Decode reads the next Bencode-encoded value from its
 // input and returns it as an any
+//
+// At the end of the input stream, before any byte of a value, Decode returns
+// io.EOF, so that a stream of values can be read until it runs out. Input
+// ending partway through a value returns io.ErrUnexpectedEOF.
 func (d *Decoder) Decode(v any) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Pointer || rv.IsNil() {
 		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
 	}
+	return d.decodeValue(rv)
+}
+
+// InputOffset returns the number of bytes of input consumed so far, which
+// after a call to Decode is the offset just past the value it decoded, where
+// the next value begins. Bytes the Decoder has buffered but not yet parsed are
+// not counted. The offset starts again from zero after Reset.
+func (d *Decoder) InputOffset() int64 {
+	return d.r.offset
+}
+
+// DecodeValue is like Decode, but decodes into v itself, for callers such as
+// generic frameworks that already hold a reflect.Value. v must be settable,
+// such as an element of a slice or a field reached through a pointer, or a
+// non-nil pointer, whose target is then decoded into as with Decode.
+func (d *Decoder) DecodeValue(v reflect.Value) error {
+	if !v.IsValid() {
+		return &InvalidUnmarshalError{}
+	}
+	if !v.CanSet() && (v.Kind() != reflect.Pointer || v.IsNil()) {
+		return fmt.Errorf("bencode: DecodeValue of unsettable %s", v.Type())
+	}
+	return d.decodeValue(v)
+}
+
+// decodeValue reads the next value from the input and decodes it into v.
+func (d *Decoder) decodeValue(rv reflect.Value) error {
+	d.r.recovered = nil
+	d.r.elements = 0
 
+	// When unmarshaling data, the encoded form of the value is sliced from it
+	// for anything but an empty interface, so that the offset of a value that
+	// does not fit its target can be reported. A stream is only captured as it
+	// is read when a RawMessage in the target needs the encoded form, as
+	// capturing copies every byte; offsets are then not known otherwise.
+	target := rv
+	for (target.Kind() == reflect.Pointer || target.Kind() == reflect.Interface) && !target.IsNil() {
+		// A pointer to an interface holding the pointer itself is decoded
+		// into as an interface, as unmarshal does.
+		if target.Kind() == reflect.Pointer && target.Elem().Kind() == reflect.Interface && target.Elem().Elem().Equal(target) {
+			target = target.Elem()
+			break
+		}
+		target = target.Elem()
+	}
+	holdsRaw := d.holdsRawMessage(target.Type())
+	capture := holdsRaw && d.r.data == nil
+	keep := capture || d.r.data != nil && (holdsRaw || target.Kind() != reflect.Interface || target.NumMethod() > 0 || len(d.types) > 0)
+	if capture {
+		d.r.capturing = true
+		d.r.capture = d.r.capture[:0]
+	}
+	start := d.r.offset
 	rawData, err := d.r.decode()
+	d.r.capturing = false
 	if err != nil {
+		if cap(d.r.capture) > maxRetainedCapture {
+			d.r.capture = nil
+		}
+		return err
+	}
+	d.tokenAdvance()
+
+	if keep {
+		d.input, d.inputOffset = d.r.capture, start
+		if !capture {
+			d.input = d.r.data[start:d.r.offset]
+		}
+	}
+	if holdsRaw {
+		d.raw = d.input
+	}
+	err = d.unmarshal(rawData, rv)
+	d.raw, d.input = nil, nil
+	if cap(d.r.capture) > maxRetainedCapture {
+		d.r.capture = nil
+	}
+	if err != nil {
+		return err
+	}
+	return d.recoveredError()
+}
+
+// recoveredError returns a *RecoveredError for the malformed values skipped
+// since the last call to Decode or DecodeList, if any.
+func (d *Decoder) recoveredError() error {
+	if len(d.r.recovered) == 0 {
+		return nil
+	}
+	return &RecoveredError{Errors: d.r.recovered}
+}
+
+// DecodeContext is like Decode, but stops with ctx.Err() once ctx is done. The
+// context is checked between the elements of lists and dictionaries, so that
+// parsing a huge or malicious input can be cancelled or given a deadline.
+// Reading a single large string is not interrupted.
+func (d *Decoder) DecodeContext(ctx context.Context, v any) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	d.r.ctx = ctx
+	defer func() { d.r.ctx = nil }()
+	return d.Decode(v)
+}
+
+// Marshal returns the Bencode encoding of v.
+//
+// Dictionary keys, whether from maps or struct fields, are written in sorted
+// order, except for structs implementing OrderedStruct, which choose their own
+// order and so produce output that is not canonical.
+//
+// A nil map is encoded as an empty dictionary and a nil slice as an empty list,
+// or an empty string for a nil []byte. Bencode has no null value, so a nil
+// pointer or interface cannot be encoded and Marshal returns an error, unless
+// it is a struct field with the "omitempty" option, which leaves the field out.
+// That option also leaves out false, 0, and empty strings, slices and maps.
+//
+// An integer or boolean struct field with the "string" option, as in
+// `bencode:"port,string"`, is written as a string of its text, such as 4:6881
+// or 4:true, and Unmarshal decodes the field from a string in that form.
+func Marshal(v any) ([]byte, error) {
+	return AppendBencode(nil, v)
+}
+
+// AppendBencode appends the Bencode encoding of v to dst and returns the
+// extended buffer. Reusing dst across calls, as a server encoding many small
+// responses might, avoids allocating a new buffer for each one.
+//
+// If an error occurs, AppendBencode returns dst unchanged.
+func AppendBencode(dst []byte, v any) ([]byte, error) {
+	return appendBencode(dst, v, false)
+}
+
+// MarshalCanonical returns the Bencode encoding of v in canonical form, which
+// gives every value exactly one encoding. It suits building the info
+// dictionary of a new torrent, whose info hash is then the hash of the result.
+// The info hash of an existing torrent must instead be taken from the original
+// bytes of its info dictionary, kept in a RawMessage, since a struct decoded
+// from them need not re-encode to the same bytes.
+//
+// Marshal already writes keys in sorted order and integers without leading
+// zeros, so its output is canonical except in two cases that
+// MarshalCanonical rules out: structs implementing OrderedStruct have their
+// keys sorted regardless, and a RawMessage, which is written verbatim, must
+// hold exactly one value in canonical form or an error is returned.
+func MarshalCanonical(v any) ([]byte, error) {
+	return appendBencode(nil, v, true)
+}
+
+// EncodedLen returns the number of bytes Marshal would produce for v, without
+// building the encoding, so that a buffer or a Content-Length header can be
+// sized up front. It fails whenever Marshal would.
+//
+// The contents of a StringReader are counted from its Len rather than read, so
+// the reader is left for the call to Marshal, but a StringReader whose reader
+// turns out shorter than its Len will then fail to marshal.
+func EncodedLen(v any) (int, error) {
+	w := writerPool.Get().(*writer)
+	defer w.release()
+	w.sizeOnly = true
+
+	var c byteCounter
+	w.w.Reset(&c)
+	if err := w.encode(reflect.ValueOf(v)); err != nil {
+		return 0, err
+	}
+	if err := w.w.Flush(); err != nil {
+		return 0, err
+	}
+	return int(c.n + w.skipped), nil
+}
 
-	return unmarshal(rawData, rv)
+// appendBencode implements AppendBencode and MarshalCanonical.
+func appendBencode(dst []byte, v any, canonical bool) ([]byte, error) {
+	w := writerPool.Get().(*writer)
+	defer w.release()
+	w.canonical = canonical
+
+	buf := appendBuffer{b: dst}
+	w.w.Reset(&buf)
+	if err := w.encode(reflect.ValueOf(v)); err != nil {
+		return dst, err
+	}
+	if err := w.w.Flush(); err != nil {
+		return dst, err
+	}
+	return buf.b, nil
+}
+
+// An Encoder writes Bencode values to an output stream.
+type Encoder struct {
+	w *writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: newWriter(w)}
+}
+
+// SetAllowedKeys restricts the keys the Encoder writes for structs of type typ
+// to those in keys. The fields of any other key are silently dropped, whatever
+// their value, which allows minimal output such as a torrent stripped of
+// identifying metadata. Keys are those used in the encoding, so they are taken
+// from the bencode struct tag where there is one.
+//
+// The allowlist applies wherever a value of type typ is encoded, including
+// when it is nested in another value. A nil keys removes the allowlist for typ.
+func (e *Encoder) SetAllowedKeys(typ reflect.Type, keys map[string]bool) {
+	if keys == nil {
+		delete(e.w.allowedKeys, typ)
+		return
+	}
+	if e.w.allowedKeys == nil {
+		e.w.allowedKeys = make(map[reflect.Type]map[string]bool)
+	}
+	e.w.allowedKeys[typ] = keys
+}
+
+// SetTagName sets the struct tag key the Encoder reads field keys and options
+// from, in place of the default "bencode".
+func (e *Encoder) SetTagName(name string) {
+	e.w.tagName = name
+}
+
+// UseStringer causes the Encoder to write values that implement error as
+// strings of their Error text, and values that implement fmt.Stringer as
+// strings of their String text where they would otherwise be unsupported,
+// such as floats, booleans and channels. This is a convenience for logging
+// opaque values, and is off by default so that no value is converted to text
+// by surprise. Strings, integers, lists, maps and structs that implement
+// fmt.Stringer are still encoded as usual.
+func (e *Encoder) UseStringer() {
+	e.w.stringers = true
+}
+
+// Encode writes the Bencode encoding of v to the stream.
+//
+// The encoding is written out as v is walked, so lists are streamed element by
+// element. Dictionary keys must be written in sorted order, so the keys of each
+// map, though not its values, are gathered and sorted before the first entry
+// is written. Encoding a map therefore holds all of its keys in memory at
+// once, and a very large dictionary is better split or modelled as a list.
+//
+// If an error occurs, part of the encoding may already have been written.
+func (e *Encoder) Encode(v any) error {
+	if err := e.w.encode(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return e.w.w.Flush()
 }
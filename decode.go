@@ -2,40 +2,353 @@ package bencode
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"strconv"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// maxPreallocSize is the largest string length for which the contents buffer
+// is allocated before any of the data has been read.
+const maxPreallocSize = 1 << 16
+
+// defaultMaxIntDigits is the number of digits an integer may have unless
+// SetMaxIntDigits says otherwise. It is far more than any legitimate value
+// needs, while keeping a run of digits with no 'e' from being read forever.
+const defaultMaxIntDigits = 1 << 16
+
+// maxRetainedCapture is the largest capture buffer a reader keeps for reuse
+// once the value it held has been unmarshaled, so that capturing one large
+// RawMessage does not pin its size for the life of the Decoder.
+const maxRetainedCapture = 1 << 16
+
+// defaultBufferSize is the size of the buffer a Decoder reads through unless
+// NewDecoderSize says otherwise, and minBufferSize the smallest it allows,
+// which leaves room for the length prefix of any string.
+const (
+	defaultBufferSize = 4096
+	minBufferSize     = 64
+)
+
+// Interned keys are limited in number and length, so that input with many
+// distinct or long keys cannot grow the cache without bound. Real keys are
+// short and drawn from a small vocabulary, and fit well within the limits.
+const (
+	maxInternedKeys   = 1024
+	maxInternedKeyLen = 64
 )
 
 // reader is a buffered reader that provides methods for decoding bencode values.
 type reader struct {
 	r *bufio.Reader
+
+	// buf is the buffer the reader allocated to wrap its source, kept for
+	// reuse by reset. It is nil while r is a *bufio.Reader of the caller's.
+	buf *bufio.Reader
+
+	// offset is the number of bytes consumed from r so far.
+	offset int64
+
+	// canonical rejects any input that is not in canonical form: dictionary
+	// keys must be strictly ascending, and integers and string lengths must
+	// not have leading zeros, a plus sign, or a negative zero.
+	canonical bool
+
+	// duplicates controls how repeated dictionary keys are handled.
+	duplicates DuplicateKeyPolicy
+
+	// maxIntDigits, if positive, limits the number of digits in an integer.
+	// It is defaultMaxIntDigits unless set otherwise.
+	maxIntDigits int
+
+	// foldKeys lowercases the ASCII letters of dictionary keys.
+	foldKeys bool
+
+	// src is the underlying source when it reports its unread length, as
+	// bytes.Reader and strings.Reader do. It is nil if the size is unknown.
+	src lenReader
+
+	// maxLengthRatio, if positive, limits a declared string length to this
+	// multiple of the remaining input. It only applies when src is known.
+	maxLengthRatio float64
+
+	// useNumber returns integers as a Number rather than an int64 or *big.Int.
+	useNumber bool
+
+	// useInt returns integers that fit in an int as an int rather than an
+	// int64. useNumber takes precedence.
+	useInt bool
+
+	// useBytes returns strings, other than dictionary keys, as a []byte
+	// rather than a string.
+	useBytes bool
+
+	// orderedDicts returns dictionaries as an *OrderedDict in input order
+	// rather than a map[string]any.
+	orderedDicts bool
+
+	// requireUTF8 rejects dictionary keys that are not valid UTF-8.
+	requireUTF8 bool
+
+	// ctx, if set, is checked between list and dictionary elements, so that
+	// decoding stops once it is cancelled.
+	ctx context.Context
+
+	// lenient skips malformed list elements and dictionary entries rather
+	// than failing, recording their errors in recovered.
+	lenient   bool
+	recovered []error
+
+	// arena, if set, provides the memory for strings and lists.
+	arena *Arena
+
+	// maxElements, if positive, limits the number of values, counting those
+	// nested in lists and dictionaries, that elements counts.
+	maxElements int
+	elements    int
+
+	// keys, if not nil, interns dictionary keys, mapping each to a single
+	// shared copy. keyBuf holds a key while it is looked up.
+	keys   map[string]string
+	keyBuf []byte
+
+	// capturing appends every byte consumed to capture, so that the encoded
+	// form of a value can be kept as it was in the input, for RawMessage.
+	capturing bool
+	capture   []byte
+
+	// data, if not nil, is the whole input, which r reads from its start, so
+	// that the encoded form of a value can be sliced from it instead of being
+	// captured.
+	data []byte
+}
+
+// lenReader is implemented by readers that know how many bytes remain unread.
+type lenReader interface {
+	Len() int
 }
 
 // newReader creates a new reader from an io.Reader.
 // If the reader is already a *bufio.Reader, it will be used directly.
 func newReader(r io.Reader) *reader {
 	if br, ok := r.(*bufio.Reader); ok {
-		return &reader{r: br}
+		return &reader{r: br, maxIntDigits: defaultMaxIntDigits}
 	}
-	return &reader{r: bufio.NewReader(r)}
+	return newReaderSize(r, defaultBufferSize)
 }
 
-func (r *reader) decode() (any, error) {
-	// Look at the first byte to determine the data type of value
+// newReaderSize creates a new reader from an io.Reader, buffering it with at
+// least size bytes. A *bufio.Reader that is already that large is used
+// directly.
+func newReaderSize(r io.Reader, size int) *reader {
+	br := bufio.NewReaderSize(r, size)
+	if br == r {
+		return &reader{r: br, maxIntDigits: defaultMaxIntDigits}
+	}
+	src, _ := r.(lenReader)
+	return &reader{r: br, buf: br, src: src, maxIntDigits: defaultMaxIntDigits}
+}
+
+// reset makes the reader read from r, as newReader would, discarding any
+// buffered input and the state of the previous input while keeping every
+// option. The reader's own buffer is reused where it has one.
+func (r *reader) reset(src io.Reader) {
+	if br, ok := src.(*bufio.Reader); ok {
+		r.r = br
+		r.src = nil
+	} else {
+		if r.buf == nil {
+			r.buf = bufio.NewReaderSize(src, max(r.r.Size(), defaultBufferSize))
+		} else {
+			r.buf.Reset(src)
+		}
+		r.r = r.buf
+		r.src, _ = src.(lenReader)
+	}
+	r.offset = 0
+	r.recovered = nil
+	r.elements = 0
+	r.capturing = false
+	r.data = nil
+}
+
+// remaining returns the number of unread bytes of input, including any that
+// are buffered, and whether that number is known.
+func (r *reader) remaining() (int64, bool) {
+	if r.src == nil {
+		return 0, false
+	}
+	return int64(r.src.Len() + r.r.Buffered()), true
+}
+
+// checkContext returns the error of the reader's context, if it has one and it
+// is done.
+func (r *reader) checkContext() error {
+	if r.ctx == nil {
+		return nil
+	}
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// recover handles err, the error from decoding an element of a list or an
+// entry of a dictionary that started at offset start. In lenient mode, a
+// malformed element is recorded in r.recovered and skipped by resynchronizing
+// at the next byte that can start a value or end the container, and recover
+// returns nil. Otherwise, or if the input ended, the context is done or the
+// element limit is reached, err is returned as it is.
+func (r *reader) recover(err error, start int64) error {
+	if !r.lenient || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		(r.ctx != nil && r.ctx.Err() != nil) || (r.maxElements > 0 && r.elements >= r.maxElements) {
+		return err
+	}
+	r.recovered = append(r.recovered, err)
+
+	// Always make progress, so that a byte that cannot be decoded is not
+	// read again and again.
+	if r.offset == start {
+		if _, err := r.readByte(); err != nil {
+			return err
+		}
+	}
+	for {
+		b, err := r.peek()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch b {
+		case 'i', 'l', 'd', 'e', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			return nil
+		}
+		_, _ = r.readByte()
+	}
+}
+
+// peek returns the next byte without consuming it. At the end of the input
+// it returns io.EOF, like readByte.
+func (r *reader) peek() (byte, error) {
+	b, err := r.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readByte reads a single byte, advancing the offset.
+func (r *reader) readByte() (byte, error) {
 	b, err := r.r.ReadByte()
+	if err == nil {
+		r.offset++
+		if r.capturing {
+			r.capture = append(r.capture, b)
+		}
+	}
+	return b, err
+}
+
+// consumed records that p has been read, advancing the offset past it and
+// appending it to the capture if there is one.
+func (r *reader) consumed(p []byte) {
+	r.offset += int64(len(p))
+	if r.capturing {
+		r.capture = append(r.capture, p...)
+	}
+}
+
+// readString reads until the first occurrence of delim, advancing the offset
+// by the number of bytes read.
+func (r *reader) readString(delim byte) (string, error) {
+	s, err := r.r.ReadString(delim)
+	r.offset += int64(len(s))
+	if r.capturing {
+		r.capture = append(r.capture, s...)
+	}
+	return s, err
+}
+
+// errTokenTooLong is returned by readStringLimit when the limit is reached.
+var errTokenTooLong = errors.New("bencode: token too long")
+
+// readStringLimit is like readString, but gives up with errTokenTooLong once
+// more than limit bytes have been read without finding delim, so that memory
+// use stays bounded. A limit of 0 disables the check.
+func (r *reader) readStringLimit(delim byte, limit int) (string, error) {
+	if limit <= 0 {
+		return r.readString(delim)
+	}
+
+	var buf []byte
+	for {
+		chunk, err := r.r.ReadSlice(delim)
+		r.consumed(chunk)
+		buf = append(buf, chunk...)
+		if len(buf) > limit {
+			return "", errTokenTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return string(buf), err
+	}
+}
+
+// readBytes reads exactly n bytes, advancing the offset by the number of bytes read.
+// The result is always newly allocated, never a view of the read buffer, so
+// decoded strings and byte slices stay valid as more input is read.
+//
+// Large reads grow the result as data arrives rather than allocating n bytes
+// up front, so a huge declared length followed by little data fails at EOF
+// instead of exhausting memory.
+func (r *reader) readBytes(n int64) ([]byte, error) {
+	if n <= maxPreallocSize {
+		buf := make([]byte, n)
+		read, err := io.ReadFull(r.r, buf)
+		r.consumed(buf[:read])
+		return buf, err
+	}
+
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r.r, n)
+	r.consumed(buf.Bytes())
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+func (r *reader) decode() (any, error) {
+	// Look at the first byte to determine the data type of value, leaving it
+	// for the respective parsing function to consume.
+	b, err := r.peek()
 	if err != nil {
 		return nil, err
 	}
 
-	// Put the byte back so the respective parsing function can consume it.
-	if err := r.r.UnreadByte(); err != nil {
-		return nil, err
+	if r.maxElements > 0 {
+		if r.elements >= r.maxElements {
+			return nil, fmt.Errorf("bencode: input exceeds %d elements", r.maxElements)
+		}
+		r.elements++
 	}
 
 	switch b {
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		if r.useBytes {
+			return r.decodeBytes()
+		}
 		return r.decodeString()
 	case 'i':
 		return r.decodeInt()
@@ -44,122 +357,555 @@ func (r *reader) decode() (any, error) {
 	case 'd':
 		return r.decodeDict()
 	default:
-		return nil, errors.New("bencode: invalid or unsupported type character")
+		return nil, r.typeCharError(b)
 	}
 }
 
+// typeCharError reports b, the next byte of input, as not starting a value.
+// Bencode has no whitespace or separators, so this is also the error for
+// input that starts with a space or a newline.
+func (r *reader) typeCharError(b byte) error {
+	return fmt.Errorf("bencode: invalid or unsupported type character %q at offset %d", b, r.offset)
+}
+
 // decodeString parses a string from the reader.
 // Format: <length>:<contents>
 func (r *reader) decodeString() (string, error) {
-	lengthStr, err := r.r.ReadString(':')
+	length, err := r.readStringLength()
+	if err != nil {
+		return "", err
+	}
+	contents, err := r.readBytes(length)
+	if err != nil {
+		return "", fmt.Errorf("bencode: failed to read string contents: %w", err)
+	}
+	if len(contents) == 0 {
+		return "", nil
+	}
+
+	// contents was allocated by readBytes for this string alone and is never
+	// written to again, so the string can share its memory rather than copy
+	// it. Strings never come from an arena, whose memory is reused.
+	return unsafe.String(&contents[0], len(contents)), nil
+}
+
+// decodeBytes parses a string from the reader into a newly allocated byte
+// slice, or one from the arena if there is one.
+// Format: <length>:<contents>
+func (r *reader) decodeBytes() ([]byte, error) {
+	length, err := r.readStringLength()
+	if err != nil {
+		return nil, err
+	}
+	var contents []byte
+	if r.arena != nil && length <= arenaChunkSize/4 {
+		contents = r.arena.bytes.alloc(int(length))
+		var read int
+		read, err = io.ReadFull(r.r, contents)
+		r.consumed(contents[:read])
+	} else {
+		contents, err = r.readBytes(length)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bencode: failed to read string contents: %w", err)
+	}
+	return contents, nil
+}
+
+// readStringLength parses the length prefix of a string, up to and including
+// its ':', leaving the reader at the start of the contents.
+func (r *reader) readStringLength() (int64, error) {
+	// The length is parsed in place in the read buffer. Any valid length fits
+	// in the buffer many times over.
+	lengthText, err := r.r.ReadSlice(':')
+	r.consumed(lengthText)
 	if err != nil {
 		if err == io.EOF {
-			return "", errors.New("bencode: invalid string format, unexpected EOF")
+			return 0, errors.New("bencode: invalid string format, missing ':' after length")
+		}
+		if err == bufio.ErrBufferFull {
+			return 0, errors.New("bencode: invalid string format, length is too long")
 		}
-		return "", fmt.Errorf("bencode: invalid string format: %w", err)
+		return 0, fmt.Errorf("bencode: invalid string format: %w", err)
+	}
+	lengthText = lengthText[:len(lengthText)-1]
+	if len(lengthText) == 0 {
+		return 0, errors.New("bencode: invalid string format, missing length before ':'")
+	}
+	if r.canonical && !isCanonicalInt(string(lengthText)) {
+		return 0, fmt.Errorf("bencode: non-canonical string length %q", string(lengthText))
 	}
-	lengthStr = lengthStr[:len(lengthStr)-1] // Remove the trailing ':'
 
-	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	length, err := parseStringLength(lengthText)
 	if err != nil {
-		return "", fmt.Errorf("bencode: invalid string length: %w", err)
+		return 0, err
 	}
+	if remaining, ok := r.remaining(); ok && r.maxLengthRatio > 0 && float64(length) > r.maxLengthRatio*float64(remaining) {
+		return 0, fmt.Errorf("bencode: string length %d exceeds %g times the %d bytes of remaining input", length, r.maxLengthRatio, remaining)
+	}
+	return length, nil
+}
 
-	contents := make([]byte, length)
-	_, err = io.ReadFull(r.r, contents)
+// parseStringLength parses text, the length prefix of a string without its
+// ':'. A length too large for an int64 is reported as out of range, which is
+// distinct from a length that is not a decimal number at all.
+func parseStringLength(text []byte) (int64, error) {
+	length, err := strconv.ParseInt(string(text), 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("bencode: string length %s out of range", text)
+	}
 	if err != nil {
-		return "", fmt.Errorf("bencode: failed to read string contents: %w", err)
+		return 0, fmt.Errorf("bencode: invalid string length %q, not a decimal number", text)
+	}
+	if length < 0 {
+		return 0, fmt.Errorf("bencode: invalid string length %d, must not be negative", length)
+	}
+	return length, nil
+}
+
+// decodeKey parses a dictionary key from the reader, checking that it is
+// valid UTF-8 if requireUTF8 is set. A key that is some other kind of value
+// is reported with a *KeyTypeError.
+func (r *reader) decodeKey() (string, error) {
+	if b, err := r.peek(); err == nil {
+		var kind string
+		switch b {
+		case 'i':
+			kind = "integer"
+		case 'l':
+			kind = "list"
+		case 'd':
+			kind = "dictionary"
+		}
+		if kind != "" {
+			return "", &KeyTypeError{Value: kind, Offset: r.offset}
+		}
 	}
 
-	return string(contents), nil
+	var key string
+	var err error
+	if r.keys != nil {
+		key, err = r.decodeInternedKey()
+	} else {
+		key, err = r.decodeString()
+	}
+	if err != nil {
+		return "", fmt.Errorf("bencode: dictionary key must be a string: %w", err)
+	}
+	if r.requireUTF8 && !utf8.ValidString(key) {
+		return "", fmt.Errorf("bencode: dictionary key %q is not valid UTF-8", key)
+	}
+	return key, nil
+}
+
+// decodeInternedKey parses a string like decodeString, but returns the copy
+// of it in r.keys if there is one, reading it into r.keyBuf rather than newly
+// allocated memory. Keys too long to intern are decoded as usual.
+func (r *reader) decodeInternedKey() (string, error) {
+	length, err := r.readStringLength()
+	if err != nil {
+		return "", err
+	}
+	if length > maxInternedKeyLen {
+		contents, err := r.readBytes(length)
+		if err != nil {
+			return "", fmt.Errorf("bencode: failed to read string contents: %w", err)
+		}
+		return unsafe.String(&contents[0], len(contents)), nil
+	}
+
+	buf := r.keyBuf[:length]
+	read, err := io.ReadFull(r.r, buf)
+	r.consumed(buf[:read])
+	if err != nil {
+		return "", fmt.Errorf("bencode: failed to read string contents: %w", err)
+	}
+	if key, ok := r.keys[string(buf)]; ok {
+		return key, nil
+	}
+	key := string(buf)
+	if len(r.keys) < maxInternedKeys {
+		r.keys[key] = key
+	}
+	return key, nil
 }
 
 // decodeInt parses an integer from the reader.
 // Format: i<integer>e
-func (r *reader) decodeInt() (int64, error) {
-	if b, err := r.r.ReadByte(); err != nil || b != 'i' {
-		return 0, errors.New("bencode: expected 'i' at start of integer")
+//
+// The result is an int64, or a *big.Int if the value does not fit in an int64.
+// If useNumber is set, it is instead a Number holding the digits as written,
+// and if useInt is set, it is an int where the value fits in one.
+func (r *reader) decodeInt() (any, error) {
+	if b, err := r.readByte(); err != nil || b != 'i' {
+		return nil, errors.New("bencode: expected 'i' at start of integer")
 	}
 
-	intStr, err := r.r.ReadString('e')
+	// Fast path: parse the integer in place in the read buffer, without
+	// copying it into a string.
+	text, err := r.r.ReadSlice('e')
+	r.consumed(text)
+	if err == nil {
+		return r.parseInt(text[:len(text)-1])
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("bencode: invalid integer format, could not find 'e': %w", err)
+	}
+
+	// Slow path: the integer is longer than the read buffer, so it is far too
+	// long for an int64. Read the rest of it, within the digit limit if there
+	// is one.
+	limit := 0
+	if r.maxIntDigits > 0 {
+		limit = r.maxIntDigits + 2 - len(text) // Allow for a sign and the trailing 'e'.
+		if limit <= 0 {
+			return nil, fmt.Errorf("bencode: integer exceeds %d digits", r.maxIntDigits)
+		}
+	}
+	text = bytes.Clone(text)
+	rest, err := r.readStringLimit('e', limit)
 	if err != nil {
-		return 0, fmt.Errorf("bencode: invalid integer format, could not find 'e': %w", err)
+		if errors.Is(err, errTokenTooLong) {
+			return nil, fmt.Errorf("bencode: integer exceeds %d digits", r.maxIntDigits)
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("bencode: invalid integer format, could not find 'e': %w", err)
+	}
+	return r.parseInt(append(text, rest[:len(rest)-1]...))
+}
+
+// parseInt validates and parses the text of an integer, without its 'i' and
+// 'e' delimiters.
+func (r *reader) parseInt(text []byte) (any, error) {
+	// Catch stray bytes here rather than leaving them to strconv, which would
+	// also accept a leading '+' and reports errors without an offset.
+	start := r.offset - 1 - int64(len(text)) // The text ends just before the 'e'.
+	if len(bytes.Trim(text, asciiSpace)) == 0 {
+		return nil, fmt.Errorf("bencode: empty integer at offset %d", start-1)
+	}
+	for i, c := range text {
+		if (c < '0' || c > '9') && (c != '-' || i != 0) {
+			return nil, fmt.Errorf("bencode: integer contains non-digit byte %q at offset %d", c, start+int64(i))
+		}
+	}
+	if r.maxIntDigits > 0 && len(bytes.TrimPrefix(text, []byte("-"))) > r.maxIntDigits {
+		return nil, fmt.Errorf("bencode: integer exceeds %d digits", r.maxIntDigits)
+	}
+	if r.canonical && !isCanonicalInt(string(text)) {
+		return nil, fmt.Errorf("bencode: non-canonical integer %q", string(text))
 	}
-	intStr = intStr[:len(intStr)-1] // Remove the trailing 'e'
 
-	val, err := strconv.ParseInt(intStr, 10, 64)
+	val, err := strconv.ParseInt(string(text), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("bencode: invalid integer value: %w", err)
+		// Bencode integers have no size limit, so fall back to arbitrary
+		// precision for values outside the int64 range.
+		if errors.Is(err, strconv.ErrRange) {
+			if b, ok := new(big.Int).SetString(string(text), 10); ok {
+				if r.useNumber {
+					return Number(text), nil
+				}
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("bencode: invalid integer value: %w", err)
 	}
 
+	if r.useNumber {
+		return Number(text), nil
+	}
+	if r.useInt {
+		if int64(int(val)) == val {
+			return int(val), nil
+		}
+		// Values beyond a 32-bit int are big, as those beyond an int64
+		// always are, so that every integer is an int or a *big.Int.
+		return big.NewInt(val), nil
+	}
 	return val, nil
 }
 
 // decodeList parses a list of Bencode values from the reader.
 // Format: l<value1><value2>...e
 func (r *reader) decodeList() ([]any, error) {
-	if b, err := r.r.ReadByte(); err != nil || b != 'l' {
-		return nil, errors.New("bencode: expected 'l' at start of list")
+	if r.arena != nil {
+		return r.decodeListArena()
 	}
-
 	list := make([]any, 0)
+	err := r.decodeListFunc(func(item any) error {
+		list = append(list, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// decodeListArena parses a list into memory from r.arena. The elements are
+// gathered on the arena's stack, which nested lists share, and copied into a
+// slice of the right length once the list ends.
+func (r *reader) decodeListArena() ([]any, error) {
+	a := r.arena
+	base := len(a.stack)
+	err := r.decodeListFunc(func(item any) error {
+		a.stack = append(a.stack, item)
+		return nil
+	})
+	items := a.stack[base:]
+	var list []any
+	if err == nil {
+		list = a.lists.alloc(len(items))
+		copy(list, items)
+	}
+	clear(items)
+	a.stack = a.stack[:base]
+	return list, err
+}
+
+// decodeListFunc parses a list from the reader, passing each element to fn as
+// it is decoded rather than collecting them. If fn returns an error, parsing
+// stops and the error is returned, leaving the rest of the list unread. At
+// the end of the input it returns io.EOF.
+// Format: l<value1><value2>...e
+func (r *reader) decodeListFunc(fn func(item any) error) error {
+	// The first byte is only consumed once it is known to open a list, so
+	// that a caller can go on to decode any other value in its place.
+	b, err := r.peek()
+	if err != nil {
+		return err
+	}
+	if b != 'l' {
+		return fmt.Errorf("bencode: expected 'l' at start of list, found %q at offset %d", b, r.offset)
+	}
+	_, _ = r.readByte()
+
 	for {
-		b, err := r.r.ReadByte()
-		if err != nil {
-			return nil, err
+		if err := r.checkContext(); err != nil {
+			return err
 		}
-		if err := r.r.UnreadByte(); err != nil {
-			return nil, err
+		b, err := r.peek()
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%w: %w", ErrUnterminatedList, io.ErrUnexpectedEOF)
+			}
+			return err
 		}
 
 		if b == 'e' {
-			_, _ = r.r.ReadByte() // Consume the 'e'
+			_, _ = r.readByte() // Consume the 'e'
 			break
 		}
 
+		start := r.offset
 		item, err := r.decode()
 		if err != nil {
-			return nil, err
+			if err := r.recover(err, start); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(item); err != nil {
+			return err
 		}
-		list = append(list, item)
 	}
 
-	return list, nil
+	return nil
 }
 
 // decodeDict parses a dictionary of Bencode values from the reader.
+// The result is a map[string]any, or an *OrderedDict if orderedDicts is set.
+// Format: d<key1><value1><key2><value2>...e
+func (r *reader) decodeDict() (any, error) {
+	dict := make(map[string]any)
+	var keys []string // Keys in input order, kept only for orderedDicts.
+	unsorted := false
+	var collected map[string]bool
+	err := r.decodeDictFunc(func(key string, keyStart int64, value any) error {
+		prev, ok := dict[key]
+		if !ok && r.orderedDicts {
+			if len(keys) > 0 && key < keys[len(keys)-1] {
+				unsorted = true
+			}
+			keys = append(keys, key)
+		}
+		if ok && r.duplicates == KeepFirst {
+			return nil
+		}
+		if ok && r.duplicates == Reject {
+			err := fmt.Errorf("bencode: duplicate dictionary key %q at offset %d", key, keyStart)
+			return r.recover(err, keyStart)
+		}
+		if ok && r.duplicates == Collect {
+			// Track which keys have been collected, since the first value
+			// of a duplicated key may itself be a list.
+			if collected[key] {
+				dict[key] = append(prev.([]any), value)
+			} else {
+				if collected == nil {
+					collected = make(map[string]bool)
+				}
+				collected[key] = true
+				dict[key] = []any{prev, value}
+			}
+			return nil
+		}
+		dict[key] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if r.orderedDicts {
+		return &OrderedDict{keys: keys, values: dict, unsorted: unsorted}, nil
+	}
+	return dict, nil
+}
+
+// decodeDictFunc parses a dictionary from the reader, passing each key, the
+// offset it starts at, and its value to fn as they are decoded rather than
+// collecting them. Duplicate keys are passed each time they appear. If fn
+// returns an error, parsing stops and the error is returned, leaving the rest
+// of the dictionary unread. At the end of the input it returns io.EOF.
 // Format: d<key1><value1><key2><value2>...e
-func (r *reader) decodeDict() (map[string]any, error) {
-	if b, err := r.r.ReadByte(); err != nil || b != 'd' {
-		return nil, errors.New("bencode: expected 'd' at start of dictionary")
+func (r *reader) decodeDictFunc(fn func(key string, keyStart int64, value any) error) error {
+	// As in decodeListFunc, the first byte is only consumed once it is known
+	// to open a dictionary.
+	b, err := r.peek()
+	if err != nil {
+		return err
+	}
+	if b != 'd' {
+		return fmt.Errorf("bencode: expected 'd' at start of dictionary, found %q at offset %d", b, r.offset)
 	}
+	_, _ = r.readByte()
 
-	dict := make(map[string]any)
+	var prevKey string
+	havePrev := false
 	for {
-		b, err := r.r.ReadByte()
-		if err != nil {
-			return nil, err
+		if err := r.checkContext(); err != nil {
+			return err
 		}
-		if err := r.r.UnreadByte(); err != nil {
-			return nil, err
+		b, err := r.peek()
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%w, ended before key: %w", ErrUnterminatedDict, io.ErrUnexpectedEOF)
+			}
+			return err
 		}
 
 		if b == 'e' {
-			_, _ = r.r.ReadByte() // Consume the 'e'
-			break
+			_, _ = r.readByte() // Consume the 'e'
+			return nil
 		}
 
-		key, err := r.decodeString()
+		start := r.offset
+		keyStart := start
+		key, err := r.decodeKey()
+		if err == nil && r.canonical && havePrev && key <= prevKey {
+			err = fmt.Errorf("bencode: dictionary key %q is not in sorted order", key)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("bencode: dictionary key must be a string: %w", err)
+			if err := r.skipEntry(err, start); err != nil {
+				return err
+			}
+			continue
+		}
+		prevKey = key
+		havePrev = true
+		if r.foldKeys {
+			key = asciiLower(key)
 		}
 
+		start = r.offset
 		value, err := r.decode()
+		if err == io.EOF {
+			err = fmt.Errorf("%w, ended before the value of key %q: %w", ErrUnterminatedDict, key, io.ErrUnexpectedEOF)
+		}
 		if err != nil {
-			return nil, err
+			if err := r.recover(err, start); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fn(key, keyStart, value); err != nil {
+			return err
 		}
-		dict[key] = value
 	}
+}
 
-	return dict, nil
+// skipEntry handles err, the error from reading a dictionary key that started
+// at offset start, by skipping the whole entry in lenient mode. A key that is
+// not a string is still read, as is the value that follows any bad key, so
+// that decoding resumes at the next key.
+func (r *reader) skipEntry(err error, start int64) error {
+	var keyErr *KeyTypeError
+	if !r.lenient || !errors.As(err, &keyErr) {
+		if err := r.recover(err, start); err != nil {
+			return err
+		}
+	} else {
+		// The key is a well-formed value of the wrong type, which is
+		// recorded and read past like any other.
+		r.recovered = append(r.recovered, err)
+		if _, err := r.decode(); err != nil {
+			if err := r.recover(err, start); err != nil {
+				return err
+			}
+		}
+	}
+
+	b, err := r.peek()
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%w, ended before the value of an invalid key: %w", ErrUnterminatedDict, io.ErrUnexpectedEOF)
+		}
+		return err
+	}
+	if b == 'e' {
+		return nil
+	}
+	start = r.offset
+	if _, err := r.decode(); err != nil {
+		return r.recover(err, start)
+	}
+	return nil
+}
+
+// isCanonicalInt reports whether s is the minimal decimal representation of an
+// integer: no leading zeros, no plus sign, and no negative zero.
+func isCanonicalInt(s string) bool {
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || digits[0] < '0' || digits[0] > '9' {
+		return false
+	}
+	if digits[0] == '0' {
+		// Zero itself is the only value that may start with a '0', and it
+		// may not be negative.
+		return s == "0"
+	}
+	return true
+}
+
+// asciiLower returns s with its ASCII letters mapped to lower case. Other
+// bytes are left as they are, so binary keys are not altered.
+func asciiLower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				if 'A' <= b[j] && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
 }
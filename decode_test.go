@@ -1,10 +1,20 @@
 package bencode
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestUnmarshalGeneric(t *testing.T) {
@@ -48,17 +58,22 @@ func TestUnmarshalGeneric(t *testing.T) {
 
 func TestUnmarshalError(t *testing.T) {
 	testCases := []struct {
-		name string
-		in   string
+		name    string
+		in      string
+		wantErr error
 	}{
 		{name: "Malformed String Length", in: "5:abc"},
 		{name: "Malformed Integer No End", in: "i42"},
-		{name: "Malformed List No End", in: "l4:spam"},
-		{name: "Malformed Dictionary No End", in: "d3:foo3:bar"},
+		{name: "Malformed List No End", in: "l4:spam", wantErr: ErrUnterminatedList},
+		{name: "Malformed Dictionary No End", in: "d3:foo3:bar", wantErr: ErrUnterminatedDict},
+		{name: "Malformed Nested List No End", in: "d3:fool4:spam", wantErr: ErrUnterminatedList},
+		{name: "Malformed Dictionary In List No End", in: "ld3:foo3:bar", wantErr: ErrUnterminatedDict},
 		{name: "Invalid Start Token", in: "x"},
 		{name: "Lone End Token", in: "e"},
 		{name: "Integer with non-digit chars", in: "i42a2e"},
-		{name: "Dictionary with non-string key", in: "di1e3:fooee"},
+		{name: "Negative String Length", in: "-1:x"},
+		{name: "Negative String Length in Key", in: "d-1:xi1ee"},
+		{name: "String Length Without Colon", in: "5"},
 	}
 
 	for _, tc := range testCases {
@@ -68,8 +83,87 @@ func TestUnmarshalError(t *testing.T) {
 			if err == nil {
 				t.Fatalf("Expected an error but got nil")
 			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Unmarshal() error = %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+func TestDecodeStringLengthError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr string
+		// scan is whether a Scanner reaches the length too, rather than
+		// rejecting the input for its type character.
+		scan bool
+	}{
+		{name: "Overflow", in: "99999999999999999999:x", wantErr: "bencode: string length 99999999999999999999 out of range", scan: true},
+		{name: "Overflow Key", in: "d99999999999999999999:xi1ee", wantErr: "string length 99999999999999999999 out of range", scan: true},
+		{name: "Max Int64", in: "9223372036854775808:", wantErr: "out of range", scan: true},
+		{name: "Non-Numeric Key", in: "dabc:i1ee", wantErr: `bencode: dictionary key must be a string: bencode: invalid string length "abc", not a decimal number`},
+		{name: "Trailing Garbage", in: "1a:x", wantErr: `invalid string length "1a", not a decimal number`, scan: true},
+		{name: "Negative Key", in: "d-1:xi1ee", wantErr: "invalid string length -1, must not be negative"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got any
+			err := Unmarshal([]byte(tc.in), &got)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Unmarshal() error = %v, want one containing %q", err, tc.wantErr)
+			}
+
+			if !tc.scan {
+				return
+			}
+			s := NewScanner(strings.NewReader(tc.in))
+			if s.Scan() || s.Err() == nil || !strings.Contains(s.Err().Error(), "string length") {
+				t.Errorf("Scanner error = %v, want a string length error", s.Err())
+			}
+		})
+	}
+}
+
+func TestKeyTypeError(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want KeyTypeError
+	}{
+		{name: "Integer Key", in: "di1e3:fooee", want: KeyTypeError{Value: "integer", Offset: 1}},
+		{name: "List Key", in: "d3:fooi1eleie", want: KeyTypeError{Value: "list", Offset: 9}},
+		{name: "Nested Dictionary Key", in: "l4:spamddei1eee", want: KeyTypeError{Value: "dictionary", Offset: 8}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got any
+			err := Unmarshal([]byte(tc.in), &got)
+			var keyErr *KeyTypeError
+			if !errors.As(err, &keyErr) {
+				t.Fatalf("Unmarshal() error = %v, want *KeyTypeError", err)
+			}
+			if *keyErr != tc.want {
+				t.Errorf("Unmarshal() error = %+v, want %+v", *keyErr, tc.want)
+			}
 		})
 	}
+
+	want := "bencode: dictionary key at offset 1 must be a string, found integer"
+	if got := (&KeyTypeError{Value: "integer", Offset: 1}).Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	// A malformed string key is a different error.
+	var v any
+	if err := Unmarshal([]byte("d3fooe"), &v); err == nil || errors.As(err, new(*KeyTypeError)) {
+		t.Errorf("Unmarshal() error = %v, want a string format error", err)
+	}
 }
 
 func TestDecoderConsecutive(t *testing.T) {
@@ -97,3 +191,1622 @@ func TestDecoderConsecutive(t *testing.T) {
 		t.Fatalf("Expected io.EOF, got %v", err)
 	}
 }
+
+func TestNewDecoderSize(t *testing.T) {
+	in := multiTrackerTorrent + "i" + strings.Repeat("9", 100) + "e"
+	for _, size := range []int{0, 16, 64, 100, 1 << 20} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			d := NewDecoderSize(strings.NewReader(in), size)
+			if got, want := d.r.r.Size(), max(size, 64); got != want {
+				t.Errorf("buffer size = %d, want %d", got, want)
+			}
+			var torrent Torrent
+			if err := d.Decode(&torrent); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if torrent.Info.Name != "example" || len(torrent.Info.Files) != 2 {
+				t.Errorf("Decode() got = %+v", torrent)
+			}
+			var big any
+			if err := d.Decode(&big); err != nil {
+				t.Fatalf("Decode() of a long integer error = %v", err)
+			}
+		})
+	}
+
+	// A large enough *bufio.Reader is read from directly, and a smaller one
+	// is wrapped.
+	br := bufio.NewReaderSize(strings.NewReader("i1e"), 8192)
+	if d := NewDecoderSize(br, 8192); d.r.r != br {
+		t.Error("NewDecoderSize() did not use a large enough *bufio.Reader directly")
+	}
+	if d := NewDecoderSize(br, 16384); d.r.r == br || d.r.r.Size() != 16384 {
+		t.Error("NewDecoderSize() did not wrap a smaller *bufio.Reader")
+	}
+}
+
+func BenchmarkNewDecoderSize(b *testing.B) {
+	var info struct {
+		Name   string `bencode:"name"`
+		Pieces []byte `bencode:"pieces"`
+	}
+	pieces := strings.Repeat("01234567890123456789", 1<<16)
+	data := []byte("d4:name7:example6:pieces" + strconv.Itoa(len(pieces)) + ":" + pieces + "e")
+
+	for _, size := range []int{4096, 64 << 10, 1 << 20} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			// A reader without WriteTo, so that the buffer is filled a
+			// piece at a time as from a file or socket.
+			r := bytes.NewReader(nil)
+			for range b.N {
+				r.Reset(data)
+				d := NewDecoderSize(struct{ io.Reader }{r}, size)
+				if err := d.Decode(&info); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderInputOffset(t *testing.T) {
+	d := NewDecoder(strings.NewReader("d4:porti6881ee5:helloi42e"))
+	if got := d.InputOffset(); got != 0 {
+		t.Errorf("InputOffset() before decoding = %d, want 0", got)
+	}
+
+	var msg struct {
+		Port int `bencode:"port"`
+	}
+	if err := d.Decode(&msg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got, want := d.InputOffset(), int64(len("d4:porti6881ee")); got != want {
+		t.Errorf("InputOffset() after the first value = %d, want %d", got, want)
+	}
+
+	var s string
+	if err := d.Decode(&s); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got, want := d.InputOffset(), int64(len("d4:porti6881ee5:hello")); got != want {
+		t.Errorf("InputOffset() after the second value = %d, want %d", got, want)
+	}
+
+	d.Reset(strings.NewReader("i1e"))
+	if got := d.InputOffset(); got != 0 {
+		t.Errorf("InputOffset() after Reset() = %d, want 0", got)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	d := NewDecoder(strings.NewReader("li1ei2ei3ee"))
+	d.RequireCanonical()
+	d.SetMaxElements(4)
+	d.UseInt()
+
+	// Leave the first input partway through a list, with more buffered.
+	if tok, err := d.Token(); err != nil || tok != Delim('l') {
+		t.Fatalf("Token() = %v, %v, want Delim('l')", tok, err)
+	}
+	buf := d.r.r
+
+	d.Reset(strings.NewReader("d1:ai1e1:b1:xe"))
+	if d.r.r != buf {
+		t.Error("Reset() did not reuse the Decoder's buffer")
+	}
+	var got any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() after Reset() error = %v", err)
+	}
+	if want := map[string]any{"a": 1, "b": "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() after Reset() got = %#v, want %#v", got, want)
+	}
+	if err := d.Decode(&got); err != io.EOF {
+		t.Errorf("Decode() at the end of the second input error = %v, want io.EOF", err)
+	}
+
+	// Options are kept, and offsets count from the start of the new input.
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{name: "Canonical", in: "d1:bi1e1:ai2ee", wantErr: "not in sorted order"},
+		{name: "Max Elements", in: "li1ei2ei3ei4ee", wantErr: "exceeds 4 elements"},
+		{name: "Offset", in: "di1ei2ee", wantErr: "at offset 1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d.Reset(strings.NewReader(tc.in))
+			var got any
+			if err := d.Decode(&got); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Decode() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	// A *bufio.Reader is read from directly, as by NewDecoder.
+	br := bufio.NewReader(strings.NewReader("i5e"))
+	d.Reset(br)
+	if d.r.r != br {
+		t.Error("Reset() did not use the *bufio.Reader directly")
+	}
+	var n any
+	if err := d.Decode(&n); err != nil || n != 5 {
+		t.Errorf("Decode() got = %#v, error = %v, want 5", n, err)
+	}
+	d.Reset(strings.NewReader("i6e"))
+	if d.r.r == br {
+		t.Error("Reset() read through the previous *bufio.Reader")
+	}
+	n = nil
+	if err := d.Decode(&n); err != nil || n != 6 {
+		t.Errorf("Decode() got = %#v, error = %v, want 6", n, err)
+	}
+}
+
+func TestDecoderRequireCanonical(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "Canonical Torrent", in: "d8:announce3:url4:infod6:lengthi10e4:name3:fooee"},
+		{name: "Reordered Keys", in: "d4:infod6:lengthi10e4:name3:fooe8:announce3:urle", wantErr: true},
+		{name: "Reordered Nested Keys", in: "d8:announce3:url4:infod4:name3:foo6:lengthi10eee", wantErr: true},
+		{name: "Duplicate Keys", in: "d3:fooi1e3:fooi2ee", wantErr: true},
+		{name: "Zero", in: "i0e"},
+		{name: "Leading Zero Integer", in: "i03e", wantErr: true},
+		{name: "Negative Zero", in: "i-0e", wantErr: true},
+		{name: "Plus Sign", in: "i+3e", wantErr: true},
+		{name: "Empty String", in: "0:"},
+		{name: "Leading Zero String Length", in: "03:foo", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.RequireCanonical()
+
+			var got any
+			err := d.Decode(&got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeConsumed(t *testing.T) {
+	data := []byte("d3:fooi42ee4:spam")
+
+	var first map[string]int
+	n, err := Decode(data, &first)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if n != 11 {
+		t.Errorf("Decode() n = %d, want 11", n)
+	}
+	if first["foo"] != 42 {
+		t.Errorf("Decode() got = %v, want foo=42", first)
+	}
+
+	var second string
+	m, err := Decode(data[n:], &second)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if m != 6 || second != "spam" {
+		t.Errorf("Decode() got %q (n = %d), want %q (n = 6)", second, m, "spam")
+	}
+}
+
+func TestDecoderDuplicateKeyPolicy(t *testing.T) {
+	testCases := []struct {
+		name   string
+		in     string
+		policy DuplicateKeyPolicy
+		want   any
+	}{
+		{name: "Keep Last", in: "d3:fooi1e3:fooi2ee", policy: KeepLast, want: map[string]any{"foo": int64(2)}},
+		{name: "Collect", in: "d3:fooi1e3:fooi2ee", policy: Collect, want: map[string]any{"foo": []any{int64(1), int64(2)}}},
+		{
+			name:   "Collect Three",
+			in:     "d3:fooi1e3:bar1:x3:fooi2e3:fooi3ee",
+			policy: Collect,
+			want:   map[string]any{"foo": []any{int64(1), int64(2), int64(3)}, "bar": "x"},
+		},
+		{
+			name:   "Collect Lists",
+			in:     "d3:fooli1ee3:fooli2eee",
+			policy: Collect,
+			want:   map[string]any{"foo": []any{[]any{int64(1)}, []any{int64(2)}}},
+		},
+		{name: "Keep First", in: "d3:fooi1e3:fooi2ee", policy: KeepFirst, want: map[string]any{"foo": int64(1)}},
+		{
+			name:   "Keep First Three",
+			in:     "d3:fooi1e3:bar1:x3:foold1:ai1eee3:fooi3ee",
+			policy: KeepFirst,
+			want:   map[string]any{"foo": int64(1), "bar": "x"},
+		},
+		{name: "Reject Unique Keys", in: "d3:bar1:x3:fooi1ee", policy: Reject, want: map[string]any{"bar": "x", "foo": int64(1)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.SetDuplicateKeyPolicy(tc.policy)
+
+			var got any
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Decode() got = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecoderRejectDuplicateKeys(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{name: "Top Level", in: "d3:fooi1e3:fooi2ee", wantErr: `duplicate dictionary key "foo" at offset 9`},
+		{name: "Nested", in: "d4:infod1:a0:1:bi1e1:a1:xee", wantErr: `duplicate dictionary key "a" at offset 19`},
+		{name: "After Other Keys", in: "d1:ai1e1:bi2e1:ai3ee", wantErr: `duplicate dictionary key "a" at offset 13`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.SetDuplicateKeyPolicy(Reject)
+
+			var got any
+			err := d.Decode(&got)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Decode() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	t.Run("Struct", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader("d4:name1:a4:name1:be"))
+		d.SetDuplicateKeyPolicy(Reject)
+		var got struct {
+			Name string `bencode:"name"`
+		}
+		if err := d.Decode(&got); err == nil {
+			t.Errorf("Decode() error = nil, got = %+v", got)
+		}
+	})
+
+	t.Run("Lenient", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader("d3:fooi1e3:fooi2e3:bar1:xe"))
+		d.SetDuplicateKeyPolicy(Reject)
+		d.Lenient()
+
+		var got any
+		err := d.Decode(&got)
+		var recErr *RecoveredError
+		if !errors.As(err, &recErr) || len(recErr.Errors) != 1 {
+			t.Fatalf("Decode() error = %v, want a *RecoveredError with one error", err)
+		}
+		if want := map[string]any{"foo": int64(1), "bar": "x"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Decode() got = %#v, want %#v", got, want)
+		}
+	})
+}
+
+func TestDecodeStringDegenerate(t *testing.T) {
+	inputs := []string{"", ":", ":abc", "::", "0", "-:", "1:", "d:abce", "d:i1ee"}
+
+	for _, in := range inputs {
+		t.Run(strconv.Quote(in), func(t *testing.T) {
+			if _, err := newReader(strings.NewReader(in)).decodeString(); err == nil {
+				t.Errorf("decodeString(%q) expected an error", in)
+			}
+
+			var got any
+			if err := Unmarshal([]byte(in), &got); err == nil {
+				t.Errorf("Unmarshal(%q) expected an error", in)
+			}
+		})
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	seeds := []string{
+		"4:spam", "i42e", "i-42e", "l4:spami42ee", "d3:foo3:bar5:helloi42ee",
+		"d4:dictd3:key5:valuee4:listli1ei2ei3eee", "0:", "le", "de", "2:\x01\x02",
+		"d2:\x01\x02i1ee", "5:abc", "i42", "l4:spam", "d3:foo3:bar", "x", "e",
+		"i42a2e", "di1e3:fooee", "-1:x", "d-1:xi1ee", "5", ":abc", "",
+		"i123456789012345678901234567890e",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v any
+		if err := Unmarshal(data, &v); err != nil {
+			return
+		}
+
+		// Anything that decodes must also encode, since the generic tree
+		// only contains types the encoder supports.
+		if _, err := Marshal(v); err != nil {
+			t.Errorf("Marshal() of decoded %q error = %v", data, err)
+		}
+	})
+}
+
+func TestDecoderMaxLengthRatio(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		ratio   float64
+		wantErr bool
+	}{
+		{name: "Disabled", in: "l4:spame", ratio: 0},
+		{name: "Within Ratio", in: "l4:spame", ratio: 1},
+		{name: "Absurd Length", in: "d6:pieces999999999999:abce", ratio: 1, wantErr: true},
+		{name: "Absurd Length Generous Ratio", in: "d6:pieces999999999999:abce", ratio: 1000, wantErr: true},
+		{name: "Too Long for Ratio", in: "5:abcd", ratio: 1, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.SetMaxLengthRatio(tc.ratio)
+
+			var got any
+			err := d.Decode(&got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	d := NewDecoder(strings.NewReader("d6:pieces999999999999:abce"))
+	d.SetMaxLengthRatio(1)
+	var got any
+	if err := d.Decode(&got); err == nil || !strings.Contains(err.Error(), "remaining input") {
+		t.Errorf("Decode() error = %v, want a length ratio error", err)
+	}
+}
+
+func TestDecoderFoldMapKeys(t *testing.T) {
+	testCases := []struct {
+		name   string
+		in     string
+		policy DuplicateKeyPolicy
+		want   any
+	}{
+		{
+			name: "Mixed Case",
+			in:   "d8:Announce3:url4:INFOd4:Name3:fooee",
+			want: map[string]any{"announce": "url", "info": map[string]any{"name": "foo"}},
+		},
+		{name: "Binary Key", in: "d2:\xc3Ai1ee", want: map[string]any{"\xc3a": int64(1)}},
+		{name: "Collision Keeps Last", in: "d3:FOOi1e3:fooi2ee", want: map[string]any{"foo": int64(2)}},
+		{
+			name:   "Collision Collected",
+			in:     "d3:FOOi1e3:fooi2ee",
+			policy: Collect,
+			want:   map[string]any{"foo": []any{int64(1), int64(2)}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.FoldMapKeys()
+			d.SetDuplicateKeyPolicy(tc.policy)
+
+			var got any
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Decode() got = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecoderMaxIntDigits(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "Within Limit", in: "i-1234567890e"},
+		{name: "Too Many Digits", in: "i12345678901e", wantErr: true},
+		{name: "Too Many Digits in List", in: "li1ei12345678901ee", wantErr: true},
+		{name: "Too Many Digits in Dict", in: "d1:ai12345678901ee", wantErr: true},
+		{name: "Million Digits", in: "i" + strings.Repeat("9", 1_000_000) + "e", wantErr: true},
+		{name: "Million Digits Unterminated", in: "i" + strings.Repeat("9", 1_000_000), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Decode into both generic and typed destinations.
+			for _, v := range []any{new(any), new([]any), new(map[string]int64), new(int64)} {
+				r := strings.NewReader(tc.in)
+				d := NewDecoder(r)
+				d.SetMaxIntDigits(10)
+
+				err := d.Decode(v)
+				if tc.wantErr {
+					if err == nil || !strings.Contains(err.Error(), "exceeds 10 digits") {
+						t.Errorf("Decode(%T) error = %v, want a digit limit error", v, err)
+					}
+					// The decoder must give up long before reading the whole input.
+					if consumed := len(tc.in) - r.Len(); consumed > 64<<10 {
+						t.Errorf("Decode(%T) consumed %d bytes before failing", v, consumed)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderDecodeList(t *testing.T) {
+	const n = 100_000
+	var sb strings.Builder
+	sb.WriteString("l")
+	for i := 1; i <= n; i++ {
+		sb.WriteString("i" + strconv.Itoa(i) + "e")
+	}
+	sb.WriteString("e4:spam")
+
+	d := NewDecoder(strings.NewReader(sb.String()))
+	var sum, count int64
+	err := d.DecodeList(func(elem any) error {
+		sum += elem.(int64)
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeList() error = %v", err)
+	}
+	if want := int64(n * (n + 1) / 2); sum != want || count != n {
+		t.Errorf("DecodeList() sum = %d over %d elements, want %d over %d", sum, count, want, n)
+	}
+
+	// The decoder continues after the list.
+	var s string
+	if err := d.Decode(&s); err != nil || s != "spam" {
+		t.Errorf("Decode() after DecodeList got %q, error = %v", s, err)
+	}
+}
+
+func TestDecoderDecodeListStop(t *testing.T) {
+	d := NewDecoder(strings.NewReader("li1ei2ei3ei4ee"))
+	var got []any
+	err := d.DecodeList(func(elem any) error {
+		got = append(got, elem)
+		if len(got) == 2 {
+			return ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeList() error = %v", err)
+	}
+	if want := []any{int64(1), int64(2)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeList() got = %v, want %v", got, want)
+	}
+
+	// A wrapped ErrStop stops decoding just the same.
+	d = NewDecoder(strings.NewReader("li1ei2ee"))
+	err = d.DecodeList(func(elem any) error {
+		return fmt.Errorf("stopping at %v: %w", elem, ErrStop)
+	})
+	if err != nil {
+		t.Errorf("DecodeList() with a wrapped ErrStop error = %v", err)
+	}
+
+	errBad := errors.New("bad element")
+	d = NewDecoder(strings.NewReader("li1ee"))
+	if err := d.DecodeList(func(any) error { return errBad }); err != errBad {
+		t.Errorf("DecodeList() error = %v, want %v", err, errBad)
+	}
+
+	// A value that is not a list is left unread, for Decode to read instead.
+	d = NewDecoder(strings.NewReader("i1e"))
+	if err := d.DecodeList(func(any) error { return nil }); err == nil {
+		t.Error("expected an error calling DecodeList on a non-list")
+	}
+	if off := d.InputOffset(); off != 0 {
+		t.Errorf("InputOffset() after DecodeList on a non-list = %d, want 0", off)
+	}
+	var n int
+	if err := d.Decode(&n); err != nil || n != 1 {
+		t.Errorf("Decode() after DecodeList on a non-list = %d, %v, want 1, nil", n, err)
+	}
+}
+
+func TestDecoderDecodeListStream(t *testing.T) {
+	// A stream of lists is read until DecodeList returns io.EOF.
+	d := NewDecoder(strings.NewReader("li1ei2eeli3ee"))
+	var got []any
+	for {
+		err := d.DecodeList(func(elem any) error {
+			got = append(got, elem)
+			return nil
+		})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("DecodeList() error = %v", err)
+		}
+	}
+	if want := []any{int64(1), int64(2), int64(3)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeList() got = %v, want %v", got, want)
+	}
+}
+
+func TestDecoderDecodeDict(t *testing.T) {
+	const n = 100_000
+	var sb strings.Builder
+	sb.WriteString("d")
+	for i := range n {
+		key := fmt.Sprintf("%020d", i)
+		sb.WriteString(strconv.Itoa(len(key)) + ":" + key + "i" + strconv.Itoa(i) + "e")
+	}
+	sb.WriteString("e4:spam")
+
+	d := NewDecoder(strings.NewReader(sb.String()))
+	var sum, count int64
+	err := d.DecodeDict(func(key string, value any) error {
+		if len(key) != 20 {
+			return fmt.Errorf("unexpected key %q", key)
+		}
+		sum += value.(int64)
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeDict() error = %v", err)
+	}
+	if want := int64(n * (n - 1) / 2); sum != want || count != n {
+		t.Errorf("DecodeDict() sum = %d over %d keys, want %d over %d", sum, count, want, n)
+	}
+
+	// The decoder continues after the dictionary.
+	var s string
+	if err := d.Decode(&s); err != nil || s != "spam" {
+		t.Errorf("Decode() after DecodeDict got %q, error = %v", s, err)
+	}
+}
+
+func TestDecoderDecodeDictStop(t *testing.T) {
+	d := NewDecoder(strings.NewReader("d1:ai1e1:bli2ee1:ai3e1:c0:e"))
+	var got []string
+	err := d.DecodeDict(func(key string, value any) error {
+		got = append(got, fmt.Sprintf("%s=%v", key, value))
+		if key == "c" {
+			return ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeDict() error = %v", err)
+	}
+	// Keys arrive in input order, duplicates included.
+	if want := []string{"a=1", "b=[2]", "a=3", "c="}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeDict() got = %q, want %q", got, want)
+	}
+
+	// A wrapped ErrStop stops decoding just the same.
+	d = NewDecoder(strings.NewReader("d1:ai1e1:bi2ee"))
+	err = d.DecodeDict(func(key string, _ any) error {
+		return fmt.Errorf("stopping at key %q: %w", key, ErrStop)
+	})
+	if err != nil {
+		t.Errorf("DecodeDict() with a wrapped ErrStop error = %v", err)
+	}
+
+	errBad := errors.New("bad entry")
+	d = NewDecoder(strings.NewReader("d1:ai1ee"))
+	if err := d.DecodeDict(func(string, any) error { return errBad }); err != errBad {
+		t.Errorf("DecodeDict() error = %v, want %v", err, errBad)
+	}
+
+	for _, in := range []string{"li1ee", "di1ei2ee", "d1:a"} {
+		d = NewDecoder(strings.NewReader(in))
+		if err := d.DecodeDict(func(string, any) error { return nil }); err == nil {
+			t.Errorf("DecodeDict() of %q returned no error", in)
+		}
+	}
+
+	// A value that is not a dictionary is left unread, for Decode to read
+	// instead.
+	d = NewDecoder(strings.NewReader("li1ee"))
+	if err := d.DecodeDict(func(string, any) error { return nil }); err == nil {
+		t.Error("expected an error calling DecodeDict on a non-dictionary")
+	}
+	if off := d.InputOffset(); off != 0 {
+		t.Errorf("InputOffset() after DecodeDict on a non-dictionary = %d, want 0", off)
+	}
+	var list []int
+	if err := d.Decode(&list); err != nil || !reflect.DeepEqual(list, []int{1}) {
+		t.Errorf("Decode() after DecodeDict on a non-dictionary = %v, %v, want [1], nil", list, err)
+	}
+}
+
+func TestDecoderDecodeDictStream(t *testing.T) {
+	// A stream of dictionaries is read until DecodeDict returns io.EOF.
+	d := NewDecoder(strings.NewReader("d1:ai1eed1:bi2ee"))
+	var got []string
+	for {
+		err := d.DecodeDict(func(key string, value any) error {
+			got = append(got, fmt.Sprintf("%s=%v", key, value))
+			return nil
+		})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("DecodeDict() error = %v", err)
+		}
+	}
+	if want := []string{"a=1", "b=2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeDict() got = %q, want %q", got, want)
+	}
+}
+
+func TestDecoderInternKeys(t *testing.T) {
+	long := strings.Repeat("k", maxInternedKeyLen+1)
+	in := "l" +
+		"d2:ip8:10.0.0.14:porti6881ee" +
+		"d2:ip8:10.0.0.24:porti6882e" + strconv.Itoa(len(long)) + ":" + long + "0:e" +
+		"d4:INFOd4:name0:ee" +
+		"e"
+
+	var want any
+	if err := NewDecoder(strings.NewReader(in)).Decode(&want); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	d := NewDecoder(strings.NewReader(in))
+	d.InternKeys()
+	var got []map[string]any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() with InternKeys error = %v", err)
+	}
+	gotAny := make([]any, len(got))
+	for i, m := range got {
+		gotAny[i] = m
+	}
+	if !reflect.DeepEqual(gotAny, want) {
+		t.Errorf("Decode() with InternKeys got = %#v, want %#v", got, want)
+	}
+
+	keyData := func(m map[string]any, key string) *byte {
+		for k := range m {
+			if k == key {
+				return unsafe.StringData(k)
+			}
+		}
+		t.Fatalf("key %q not found in %v", key, m)
+		return nil
+	}
+	if keyData(got[0], "port") != keyData(got[1], "port") {
+		t.Error("repeated key \"port\" was not shared")
+	}
+
+	// The cache persists across values.
+	var next map[string]any
+	d = NewDecoder(strings.NewReader("d2:ipi1eed2:ipi2ee"))
+	d.InternKeys()
+	if err := d.Decode(&next); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	first := keyData(next, "ip")
+	next = nil
+	if err := d.Decode(&next); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if keyData(next, "ip") != first {
+		t.Error("key \"ip\" was not shared across values")
+	}
+}
+
+func TestDecoderInternKeysLimit(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("d")
+	for i := range 2 * maxInternedKeys {
+		key := fmt.Sprintf("%06d", i)
+		sb.WriteString("6:" + key + "i" + strconv.Itoa(i) + "e")
+	}
+	sb.WriteString("e")
+
+	d := NewDecoder(strings.NewReader(sb.String()))
+	d.InternKeys()
+	var got map[string]int
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if len(got) != 2*maxInternedKeys || got["002047"] != 2047 {
+		t.Errorf("Decode() got %d keys, with 002047 = %d", len(got), got["002047"])
+	}
+	if n := len(d.r.keys); n != maxInternedKeys {
+		t.Errorf("interned %d keys, want %d", n, maxInternedKeys)
+	}
+}
+
+func TestDecoderRequireUTF8(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    any
+		wantErr bool
+	}{
+		{name: "ASCII Key", in: "d4:name3:fooe", want: map[string]any{"name": "foo"}},
+		{name: "Multibyte Key", in: "d5:caf\xc3\xa9i1ee", want: map[string]any{"café": int64(1)}},
+		{name: "Binary Value", in: "d6:pieces2:\xff\xfee", want: map[string]any{"pieces": "\xff\xfe"}},
+		{name: "Invalid Key", in: "d2:\xff\xfei1ee", wantErr: true},
+		{name: "Truncated Multibyte Key", in: "d4:caf\xc3i1ee", wantErr: true},
+		{name: "Invalid Nested Key", in: "d4:infod1:\x80i1eee", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.RequireUTF8()
+
+			var got any
+			err := d.Decode(&got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Decode() got = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+
+	// Without the option, invalid keys are kept as they are.
+	var got map[string]any
+	if err := Unmarshal([]byte("d2:\xff\xfei1ee"), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if _, ok := got["\xff\xfe"]; !ok {
+		t.Errorf("Unmarshal() got = %#v, want the binary key", got)
+	}
+}
+
+func BenchmarkDecodeInt(b *testing.B) {
+	data := bytes.Repeat([]byte("i1234567890e"), 1000)
+	src := bytes.NewReader(data)
+	r := newReader(src)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		src.Reset(data)
+		r.r.Reset(src)
+		for j := 0; j < 1000; j++ {
+			if _, err := r.decodeInt(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeDict(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteByte('d')
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(1000+i)
+		buf.WriteString(strconv.Itoa(len(key)) + ":" + key + "i" + strconv.Itoa(i*123457) + "e")
+	}
+	buf.WriteByte('e')
+	data := buf.Bytes()
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v map[string]any
+		if err := Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeIntLong(t *testing.T) {
+	// Integers longer than the read buffer are decoded on a slower path, which
+	// must apply the same validation as the fast one.
+	zeros := strings.Repeat("0", 5000)
+	huge := "1" + zeros
+
+	testCases := []struct {
+		name      string
+		in        string
+		canonical bool
+		maxDigits int
+		want      any
+		wantErr   bool
+	}{
+		{name: "Leading Zeros", in: "i" + zeros + "7e", want: int64(7)},
+		{name: "Negative Leading Zeros", in: "i-" + zeros + "7e", want: int64(-7)},
+		{name: "Huge", in: "i" + huge + "e", want: bigInt(huge)},
+		{name: "Canonical Huge", in: "i-" + huge + "e", canonical: true, want: bigInt("-" + huge)},
+		{name: "Canonical Leading Zeros", in: "i" + zeros + "7e", canonical: true, wantErr: true},
+		{name: "Within Digit Limit", in: "i" + huge + "e", maxDigits: 5001, want: bigInt(huge)},
+		{name: "Beyond Digit Limit", in: "i" + huge + "e", maxDigits: 5000, wantErr: true},
+		{name: "Invalid Character", in: "i" + zeros + "x1e", wantErr: true},
+		{name: "Unterminated", in: "i" + huge, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			if tc.canonical {
+				d.RequireCanonical()
+			}
+			d.SetMaxIntDigits(tc.maxDigits)
+
+			var got any
+			err := d.Decode(&got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Decode() got = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// bigInt parses the decimal digits s, which must be valid.
+func bigInt(s string) *big.Int {
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big.Int " + s)
+	}
+	return b
+}
+
+func BenchmarkDecodeInternKeys(b *testing.B) {
+	data := announceList(200)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	r := bytes.NewReader(nil)
+	for range b.N {
+		r.Reset(data)
+		d := NewDecoder(r)
+		d.InternKeys()
+		var v any
+		if err := d.Decode(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeTorrent(b *testing.B) {
+	type file struct {
+		Length int64    `bencode:"length"`
+		Path   []string `bencode:"path"`
+	}
+	type info struct {
+		Files       []file `bencode:"files"`
+		Name        string `bencode:"name"`
+		PieceLength int64  `bencode:"piece length"`
+		Pieces      []byte `bencode:"pieces"`
+	}
+	type torrent struct {
+		Announce string `bencode:"announce"`
+		Info     info   `bencode:"info"`
+	}
+
+	v := torrent{
+		Announce: "http://tracker.example.com:6969/announce",
+		Info: info{
+			Name:        "example",
+			PieceLength: 1 << 18,
+			Pieces:      bytes.Repeat([]byte("0123456789abcdefghij"), 5000),
+		},
+	}
+	for i := 0; i < 100; i++ {
+		v.Info.Files = append(v.Info.Files, file{Length: int64(i) << 20, Path: []string{"dir", "file" + strconv.Itoa(i)}})
+	}
+	data, err := Marshal(v)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var got torrent
+		if err := Unmarshal(data, &got); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnmarshalConcurrent(t *testing.T) {
+	// Unmarshal reuses decoders across calls, which must not leak options or
+	// data from one call into another running at the same time.
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				want := map[string]any{"g": int64(g), "i": int64(i), "s": strings.Repeat("x", i)}
+				data, err := Marshal(want)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var got any
+				if err := Unmarshal(data, &got); err != nil {
+					t.Error(err)
+					return
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Unmarshal() got = %v, want %v", got, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkUnmarshalParallel(b *testing.B) {
+	data := []byte("d8:completei12e10:incompletei3e8:intervali1800e5:peers12:\x7f\x00\x00\x01\x1a\xe1\x0a\x00\x00\x02\x1a\xe1e")
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var resp struct {
+				Complete   int    `bencode:"complete"`
+				Incomplete int    `bencode:"incomplete"`
+				Interval   int    `bencode:"interval"`
+				Peers      []byte `bencode:"peers"`
+			}
+			if err := Unmarshal(data, &resp); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDecoderResultsNotAliased(t *testing.T) {
+	// A small read buffer is refilled by every value, so any decoded value
+	// that aliased it would change when the next one is read.
+	in := "5:hello" + "d4:infod4:name3:fooee" + "5:world" + strings.Repeat("5:xxxxx", 10)
+	d := NewDecoder(bufio.NewReaderSize(strings.NewReader(in), 16))
+
+	var b []byte
+	var raw map[string]RawMessage
+	var s string
+	if err := d.Decode(&b); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if err := d.Decode(&raw); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if err := d.Decode(&s); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	for {
+		var rest string
+		if err := d.Decode(&rest); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+	}
+
+	if string(b) != "hello" {
+		t.Errorf("[]byte changed to %q after further reads", b)
+	}
+	if string(raw["info"]) != "d4:name3:fooe" {
+		t.Errorf("RawMessage changed to %q after further reads", raw["info"])
+	}
+	if s != "world" {
+		t.Errorf("string changed to %q after further reads", s)
+	}
+}
+
+// cancelReader reads from r, calling cancel once more than n bytes have been
+// read.
+type cancelReader struct {
+	r      io.Reader
+	n      int
+	cancel func()
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n -= n
+	if c.n < 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestDecoderDecodeContext(t *testing.T) {
+	data := "l" + strings.Repeat("d3:keyli1ei2eee", 200000) + "e"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDecoder(&cancelReader{r: strings.NewReader(data), n: len(data) / 10, cancel: cancel})
+
+	var v any
+	err := d.DecodeContext(ctx, &v)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DecodeContext() error = %v, want context.Canceled", err)
+	}
+	if consumed := d.r.offset; consumed > int64(len(data))/5 {
+		t.Errorf("DecodeContext() read %d of %d bytes before stopping", consumed, len(data))
+	}
+
+	// A context that is already done stops decoding before anything is read.
+	d = NewDecoder(strings.NewReader("i1e"))
+	if err := d.DecodeContext(ctx, &v); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecodeContext() error = %v, want context.Canceled", err)
+	}
+
+	// A live context does not affect decoding.
+	var got []int
+	if err := NewDecoder(strings.NewReader("li1ei2ee")).DecodeContext(context.Background(), &got); err != nil {
+		t.Fatalf("DecodeContext() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("DecodeContext() got = %v, want [1 2]", got)
+	}
+}
+
+func TestDecoderDecodeContextDeadline(t *testing.T) {
+	// An input that never ends would be read forever without a deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	r := io.MultiReader(strings.NewReader("l"), repeatReader("i1e"))
+	start := time.Now()
+	var v any
+	err := NewDecoder(r).DecodeContext(ctx, &v)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DecodeContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("DecodeContext() took %v to stop", elapsed)
+	}
+}
+
+// repeatReader is an io.Reader that repeats its contents forever.
+type repeatReader string
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r[i%len(r)]
+	}
+	return len(p) - len(p)%len(r), nil
+}
+
+func TestDecodeEmptyInput(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		// wantUnmarshal and wantDecoder are the errors expected, or nil to
+		// expect some other error.
+		wantUnmarshal error
+		wantDecoder   error
+	}{
+		{name: "Empty", in: "", wantUnmarshal: io.ErrUnexpectedEOF, wantDecoder: io.EOF},
+		{name: "Space", in: " "},
+		{name: "Whitespace", in: " \t\r\n"},
+		{name: "Newline Before Value", in: "\ni1e"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			check := func(fn string, err, want error) {
+				t.Helper()
+				switch {
+				case err == nil:
+					t.Errorf("%s() returned no error", fn)
+				case want != nil && err != want:
+					t.Errorf("%s() error = %v, want %v", fn, err, want)
+				case want == nil && (err == io.EOF || !strings.Contains(err.Error(), "offset 0")):
+					t.Errorf("%s() error = %v, want one naming the offending byte", fn, err)
+				}
+			}
+
+			var v any
+			check("Unmarshal", Unmarshal([]byte(tc.in), &v), tc.wantUnmarshal)
+			n, err := Decode([]byte(tc.in), &v)
+			check("Decode", err, tc.wantUnmarshal)
+			if n != 0 {
+				t.Errorf("Decode() n = %d, want 0", n)
+			}
+			check("Decoder.Decode", NewDecoder(strings.NewReader(tc.in)).Decode(&v), tc.wantDecoder)
+		})
+	}
+}
+
+func TestDecodeIntNonDigit(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "Letter", in: "i42a2e", want: `bencode: integer contains non-digit byte 'a' at offset 3`},
+		{name: "Space", in: "i4 2e", want: `bencode: integer contains non-digit byte ' ' at offset 2`},
+		{name: "Plus Sign", in: "i+5e", want: `bencode: integer contains non-digit byte '+' at offset 1`},
+		{name: "NUL", in: "i1\x00e", want: `bencode: integer contains non-digit byte '\x00' at offset 2`},
+		{name: "Double Minus", in: "i--5e", want: `bencode: integer contains non-digit byte '-' at offset 2`},
+		{name: "Inner Minus", in: "i5-5e", want: `bencode: integer contains non-digit byte '-' at offset 2`},
+		{name: "In List", in: "li1ei2xee", want: `bencode: integer contains non-digit byte 'x' at offset 6`},
+		{name: "Long", in: "i" + strings.Repeat("1", 5000) + "xe", want: `bencode: integer contains non-digit byte 'x' at offset 5001`},
+		{name: "Empty", in: "ie", want: `bencode: empty integer at offset 0`},
+		{name: "Blank", in: "i e", want: `bencode: empty integer at offset 0`},
+		{name: "Whitespace", in: "i \t\r\ne", want: `bencode: empty integer at offset 0`},
+		{name: "Empty In List", in: "li1eiee", want: `bencode: empty integer at offset 4`},
+		{name: "Empty Long", in: "i" + strings.Repeat(" ", 5000) + "e", want: `bencode: empty integer at offset 0`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got any
+			err := Unmarshal([]byte(tc.in), &got)
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("Unmarshal() error = %v, want %s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecoderLenient(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         string
+		want       any
+		wantErrors int
+	}{
+		{name: "Bad Integer", in: "li1ei4x2ei3ee", want: []any{int64(1), int64(3)}, wantErrors: 1},
+		{name: "Stray Bytes", in: "l4:spamxyzi3ee", want: []any{"spam", int64(3)}, wantErrors: 1},
+		{name: "Several", in: "li1xei2xei3ee", want: []any{int64(3)}, wantErrors: 2},
+		{name: "Bad Value", in: "d1:ai1e1:bi2x3e1:ci3ee", want: map[string]any{"a": int64(1), "c": int64(3)}, wantErrors: 1},
+		{name: "Integer Key", in: "d1:ai1ei5ei6e1:ci3ee", want: map[string]any{"a": int64(1), "c": int64(3)}, wantErrors: 1},
+		{name: "Bad Key Length", in: "d1:ai1e2x:bbi2e1:ci3ee", want: map[string]any{"a": int64(1), "c": int64(3)}, wantErrors: 1},
+		{name: "Nested", in: "ld1:ai1x1ee4:spame", want: []any{map[string]any{}, "spam"}, wantErrors: 1},
+		{name: "Well-Formed", in: "li1ei2ee", want: []any{int64(1), int64(2)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var strict any
+			if err := Unmarshal([]byte(tc.in), &strict); (err == nil) != (tc.wantErrors == 0) {
+				t.Errorf("Unmarshal() error = %v without Lenient", err)
+			}
+
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.Lenient()
+			var got any
+			err := d.Decode(&got)
+			var recErr *RecoveredError
+			switch {
+			case tc.wantErrors == 0 && err != nil:
+				t.Fatalf("Decode() error = %v", err)
+			case tc.wantErrors > 0 && !errors.As(err, &recErr):
+				t.Fatalf("Decode() error = %v, want *RecoveredError", err)
+			case recErr != nil && len(recErr.Errors) != tc.wantErrors:
+				t.Errorf("Decode() recovered from %d errors, want %d: %v", len(recErr.Errors), tc.wantErrors, recErr.Errors)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Decode() got = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecoderLenientTorrent(t *testing.T) {
+	// The length of the first file is corrupt, which loses that file's
+	// length but none of the rest of the torrent.
+	in := strings.Replace(multiTrackerTorrent, "6:lengthi1024e", "6:lengthi10?4e", 1)
+	d := NewDecoder(strings.NewReader(in))
+	d.Lenient()
+	var got Torrent
+	err := d.Decode(&got)
+	var recErr *RecoveredError
+	if !errors.As(err, &recErr) || len(recErr.Errors) != 1 {
+		t.Fatalf("Decode() error = %v, want a *RecoveredError with one error", err)
+	}
+	want := fmt.Sprintf("bencode: skipped a malformed value: integer contains non-digit byte '?' at offset %d", strings.Index(in, "?"))
+	if err.Error() != want {
+		t.Errorf("Decode() error = %q, want %q", err, want)
+	}
+	wantFiles := []TorrentFile{{Path: []string{"dir", "a.txt"}}, {Length: 2048, Path: []string{"b.txt"}}}
+	if !reflect.DeepEqual(got.Info.Files, wantFiles) {
+		t.Errorf("Decode() files = %+v, want %+v", got.Info.Files, wantFiles)
+	}
+	if got.Info.Name != "example" || len(got.AnnounceList) != 2 {
+		t.Errorf("Decode() lost values around the malformed one: %+v", got)
+	}
+}
+
+func TestDecoderLenientUnrecoverable(t *testing.T) {
+	for _, in := range []string{"li1ei4x2e", "d1:ai1x", "l4:spamxyz"} {
+		d := NewDecoder(strings.NewReader(in))
+		d.Lenient()
+		var got any
+		if err := d.Decode(&got); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Decode(%q) error = %v, want io.ErrUnexpectedEOF", in, err)
+		}
+	}
+
+	// Top-level values have nothing to resume within.
+	d := NewDecoder(strings.NewReader("i4x2e"))
+	d.Lenient()
+	var got any
+	if err := d.Decode(&got); err == nil || errors.As(err, new(*RecoveredError)) {
+		t.Errorf("Decode() error = %v, want a syntax error", err)
+	}
+}
+
+func TestDecoderLenientDecodeList(t *testing.T) {
+	d := NewDecoder(strings.NewReader("li1ei2?ei3ee"))
+	d.Lenient()
+	var got []any
+	err := d.DecodeList(func(elem any) error {
+		got = append(got, elem)
+		return nil
+	})
+	if !errors.As(err, new(*RecoveredError)) {
+		t.Errorf("DecodeList() error = %v, want *RecoveredError", err)
+	}
+	if want := []any{int64(1), int64(3)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeList() got = %v, want %v", got, want)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestDecodeIntDefaultDigitLimit(t *testing.T) {
+	// A 10MB run of digits with no 'e' is rejected once it passes the
+	// default limit, without reading the rest of it.
+	digits := strings.Repeat("1", 10<<20)
+	want := fmt.Sprintf("bencode: integer exceeds %d digits", defaultMaxIntDigits)
+
+	for _, in := range []string{"i" + digits, "i" + digits + "e", "li1ei" + digits} {
+		src := &countingReader{r: strings.NewReader(in)}
+		var got any
+		err := NewDecoder(src).Decode(&got)
+		if err == nil || err.Error() != want {
+			t.Errorf("Decode() error = %v, want %s", err, want)
+		}
+		if src.n > 2*defaultMaxIntDigits {
+			t.Errorf("Decode() read %d bytes before failing", src.n)
+		}
+
+		if err := Unmarshal([]byte(in), &got); err == nil || err.Error() != want {
+			t.Errorf("Unmarshal() error = %v, want %s", err, want)
+		}
+
+		s := NewScanner(strings.NewReader(in))
+		if s.Scan() || s.Err() == nil || s.Err().Error() != want {
+			t.Errorf("Scanner.Err() = %v, want %s", s.Err(), want)
+		}
+	}
+
+	// The limit can be lifted for the rare input that needs it.
+	in := "i" + strings.Repeat("1", 2*defaultMaxIntDigits) + "e"
+	d := NewDecoder(strings.NewReader(in))
+	d.SetMaxIntDigits(0)
+	var got any
+	if err := d.Decode(&got); err != nil {
+		t.Errorf("Decode() without a limit error = %v", err)
+	}
+	if err := Unmarshal([]byte(in), &got); err == nil {
+		t.Error("Unmarshal() beyond the default limit returned no error")
+	}
+}
+
+func TestDecoderDecodeValue(t *testing.T) {
+	// A value built by reflection, such as a new element of a slice, is
+	// decoded into directly.
+	type file struct {
+		Name string `bencode:"name"`
+		Size int64  `bencode:"size"`
+	}
+	files := reflect.MakeSlice(reflect.TypeOf([]file{}), 2, 2)
+	d := NewDecoder(strings.NewReader("d4:name1:a4:sizei1eed4:name1:b4:sizei2ee"))
+	for i := range files.Len() {
+		if err := d.DecodeValue(files.Index(i)); err != nil {
+			t.Fatalf("DecodeValue() error = %v", err)
+		}
+	}
+	want := []file{{Name: "a", Size: 1}, {Name: "b", Size: 2}}
+	if got := files.Interface().([]file); !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeValue() got = %+v, want %+v", got, want)
+	}
+
+	// So is a settable value from reflect.New.
+	m := reflect.New(reflect.TypeOf(map[string]int{})).Elem()
+	if err := NewDecoder(strings.NewReader("d1:ki7ee")).DecodeValue(m); err != nil {
+		t.Fatalf("DecodeValue() error = %v", err)
+	}
+	if got := m.Interface().(map[string]int); !reflect.DeepEqual(got, map[string]int{"k": 7}) {
+		t.Errorf("DecodeValue() got = %v", got)
+	}
+
+	// A non-nil pointer need not be settable itself.
+	var n int
+	if err := NewDecoder(strings.NewReader("i42e")).DecodeValue(reflect.ValueOf(&n)); err != nil || n != 42 {
+		t.Errorf("DecodeValue() got = %d, error = %v", n, err)
+	}
+
+	for _, v := range []reflect.Value{{}, reflect.ValueOf(1), reflect.ValueOf((*int)(nil)), reflect.ValueOf(struct{ A int }{}).Field(0)} {
+		if err := NewDecoder(strings.NewReader("i1e")).DecodeValue(v); err == nil {
+			t.Errorf("DecodeValue(%v) returned no error", v)
+		}
+	}
+}
+
+func TestDecodeDictTruncated(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want string
+	}{
+		{in: "d", want: "bencode: unterminated dictionary, ended before key: unexpected EOF"},
+		{in: "d3:fooi1e", want: "bencode: unterminated dictionary, ended before key: unexpected EOF"},
+		{in: "d3:foo", want: `bencode: unterminated dictionary, ended before the value of key "foo": unexpected EOF`},
+		{in: "ld3:bar", want: `bencode: unterminated dictionary, ended before the value of key "bar": unexpected EOF`},
+		{in: "d1:ad", want: "bencode: unterminated dictionary, ended before key: unexpected EOF"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			var got any
+			err := Unmarshal([]byte(tc.in), &got)
+			if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrUnterminatedDict) {
+				t.Errorf("Unmarshal() error = %v, want io.ErrUnexpectedEOF and ErrUnterminatedDict", err)
+			}
+			if err != nil && err.Error() != tc.want {
+				t.Errorf("Unmarshal() error = %q, want %q", err, tc.want)
+			}
+
+			// A Decoder reading a stream reports the same, rather than the
+			// io.EOF that marks a clean end between values.
+			if err := NewDecoder(strings.NewReader(tc.in)).Decode(&got); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Decode() error = %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+
+	// In lenient mode, a key that is not a string is read past, and the
+	// dictionary can end where its value should start.
+	d := NewDecoder(strings.NewReader("di1e"))
+	d.Lenient()
+	var got any
+	err := d.Decode(&got)
+	want := "bencode: unterminated dictionary, ended before the value of an invalid key: unexpected EOF"
+	if !errors.Is(err, ErrUnterminatedDict) || err.Error() != want {
+		t.Errorf("Decode() error = %v, want %q", err, want)
+	}
+}
+
+func TestReaderPeek(t *testing.T) {
+	r := newReader(strings.NewReader("i1e"))
+	for range 2 {
+		if b, err := r.peek(); b != 'i' || err != nil {
+			t.Fatalf("peek() = %q, %v, want 'i', nil", b, err)
+		}
+	}
+	if r.offset != 0 {
+		t.Errorf("peek() advanced the offset to %d", r.offset)
+	}
+	if _, err := r.decode(); err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	if _, err := r.peek(); err != io.EOF {
+		t.Errorf("peek() at end error = %v, want io.EOF", err)
+	}
+	if r.offset != 3 {
+		t.Errorf("offset = %d, want 3", r.offset)
+	}
+
+	// Reaching the end where a value should start is an io.EOF for a whole
+	// value, but unexpected inside a list or dictionary.
+	if _, err := newReader(strings.NewReader("")).decode(); err != io.EOF {
+		t.Errorf("decode() of empty input error = %v, want io.EOF", err)
+	}
+	if _, err := newReader(strings.NewReader("d1:a")).decode(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("decode() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDecoderMaxElements(t *testing.T) {
+	// A million one-byte integers is only 3MB of input, but far more memory
+	// once decoded.
+	huge := "l" + strings.Repeat("i1e", 1000000) + "e"
+	src := &countingReader{r: strings.NewReader(huge)}
+	d := NewDecoder(src)
+	d.SetMaxElements(1000)
+	var got any
+	err := d.Decode(&got)
+	if want := "bencode: input exceeds 1000 elements"; err == nil || err.Error() != want {
+		t.Fatalf("Decode() error = %v, want %s", err, want)
+	}
+	if src.n > 64<<10 {
+		t.Errorf("Decode() read %d bytes before failing", src.n)
+	}
+
+	testCases := []struct {
+		name    string
+		in      string
+		max     int
+		wantErr bool
+	}{
+		{name: "Scalar", in: "i1e", max: 1},
+		{name: "List At Limit", in: "li1ei2ee", max: 3},
+		{name: "List Over Limit", in: "li1ei2ee", max: 2, wantErr: true},
+		{name: "Keys Not Counted", in: "d1:ai1e1:bi2ee", max: 3},
+		{name: "Nested", in: "lldeee", max: 3},
+		{name: "Nested Over Limit", in: "llldeeee", max: 3, wantErr: true},
+		{name: "No Limit", in: huge, max: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.SetMaxElements(tc.max)
+			var got any
+			if err := d.Decode(&got); (err != nil) != tc.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	// The count starts again for each value in a stream.
+	d = NewDecoder(strings.NewReader("li1eeli2eeli3ee"))
+	d.SetMaxElements(2)
+	for range 3 {
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+	}
+
+	// Recovering from malformed values in lenient mode stops at the limit.
+	d = NewDecoder(strings.NewReader("l" + strings.Repeat("ixe", 100) + "e"))
+	d.Lenient()
+	d.SetMaxElements(10)
+	if err := d.Decode(&got); err == nil || errors.As(err, new(*RecoveredError)) {
+		t.Errorf("Decode() error = %v, want the element limit", err)
+	}
+}
+
+func TestDecoderUseInt(t *testing.T) {
+	in := "d4:listli1ei-2ee4:sizei5000000000e3:bigi123456789012345678901234567890ee"
+	d := NewDecoder(strings.NewReader(in))
+	d.UseInt()
+	var got any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := map[string]any{
+		"list": []any{1, -2},
+		"big":  bigInt("123456789012345678901234567890"),
+	}
+	if size := int64(5000000000); strconv.IntSize == 64 {
+		want["size"] = int(size)
+	} else {
+		want["size"] = big.NewInt(5000000000)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %#v, want %#v", got, want)
+	}
+
+	// Typed values decode as usual.
+	var typed struct {
+		List []int64 `bencode:"list"`
+		Size int64   `bencode:"size"`
+		Big  any     `bencode:"big"`
+	}
+	d = NewDecoder(strings.NewReader(in))
+	d.UseInt()
+	if err := d.Decode(&typed); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(typed.List, []int64{1, -2}) || typed.Size != 5000000000 {
+		t.Errorf("Decode() got = %+v", typed)
+	}
+
+	// So do tokens.
+	d = NewDecoder(strings.NewReader("li7ee"))
+	d.UseInt()
+	if _, err := d.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok, err := d.Token(); tok != 7 || err != nil {
+		t.Errorf("Token() = %#v, %v, want int 7", tok, err)
+	}
+
+	// UseNumber takes precedence.
+	d = NewDecoder(strings.NewReader("i7e"))
+	d.UseInt()
+	d.UseNumber()
+	got = nil
+	if err := d.Decode(&got); err != nil || got != Number("7") {
+		t.Errorf("Decode() got = %#v, %v, want Number 7", got, err)
+	}
+}
+
+func TestDecoderUseBytes(t *testing.T) {
+	in := "d4:infod4:name4:test6:pieces4:\x00\xff\x10\x80e4:tagsl1:a0:ee"
+	d := NewDecoder(strings.NewReader(in))
+	d.UseBytes()
+	var got any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := map[string]any{
+		"info": map[string]any{
+			"name":   []byte("test"),
+			"pieces": []byte("\x00\xff\x10\x80"),
+		},
+		"tags": []any{[]byte("a"), []byte{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %#v, want %#v", got, want)
+	}
+
+	// Typed values decode as usual, while interfaces among them get bytes.
+	var typed struct {
+		Info struct {
+			Name   string `bencode:"name"`
+			Pieces []byte `bencode:"pieces"`
+		} `bencode:"info"`
+		Tags []any `bencode:"tags"`
+	}
+	d = NewDecoder(strings.NewReader(in))
+	d.UseBytes()
+	if err := d.Decode(&typed); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if typed.Info.Name != "test" || string(typed.Info.Pieces) != "\x00\xff\x10\x80" {
+		t.Errorf("Decode() Info = %+v", typed.Info)
+	}
+	if want := []any{[]byte("a"), []byte{}}; !reflect.DeepEqual(typed.Tags, want) {
+		t.Errorf("Decode() Tags = %#v, want %#v", typed.Tags, want)
+	}
+
+	// A type key given as bytes still selects a registered type.
+	d = NewDecoder(strings.NewReader("d2:idi7e4:type4:pinge"))
+	d.UseBytes()
+	d.SetTypeKey("type")
+	d.RegisterType("ping", func() any { return new(pingMessage) })
+	var msg any
+	if err := d.Decode(&msg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if want := (&pingMessage{Type: "ping", ID: 7}); !reflect.DeepEqual(msg, want) {
+		t.Errorf("Decode() got = %#v, want %#v", msg, want)
+	}
+}
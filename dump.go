@@ -0,0 +1,125 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Dump decodes the first Bencode value in data and writes it to w in an
+// indented, human-readable form, as a debugging aid for inspecting torrents
+// and tracker responses. Dictionaries are shown in braces with their keys in
+// input order, and lists in brackets, with one element per line:
+//
+//	{
+//	  "info": {
+//	    "length": 12,
+//	    "pieces": <4 bytes: 0102fe7f>
+//	  },
+//	  "tags": [
+//	    "a",
+//	    "b"
+//	  ]
+//	}
+//
+// Strings that are printable text are shown quoted, and other strings, such
+// as piece hashes, are shown as their length and hex digits.
+func Dump(data []byte, w io.Writer) error {
+	r := newReader(bytes.NewReader(data))
+	r.orderedDicts = true
+	v, err := r.decode()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	dumpValue(&buf, v, 0)
+	buf.WriteByte('\n')
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// dumpValue writes v, a value in the generic form returned by reader.decode,
+// to buf, indenting the lines after the first by depth levels.
+func dumpValue(buf *bytes.Buffer, v any, depth int) {
+	switch v := v.(type) {
+	case string:
+		dumpString(buf, v)
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case *big.Int:
+		buf.WriteString(v.String())
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for i, item := range v {
+			dumpIndent(buf, depth+1)
+			dumpValue(buf, item, depth+1)
+			if i < len(v)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		dumpIndent(buf, depth)
+		buf.WriteByte(']')
+	case *OrderedDict:
+		if v.Len() == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		for i, key := range v.keys {
+			dumpIndent(buf, depth+1)
+			dumpString(buf, key)
+			buf.WriteString(": ")
+			dumpValue(buf, v.values[key], depth+1)
+			if i < len(v.keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		dumpIndent(buf, depth)
+		buf.WriteByte('}')
+	default:
+		fmt.Fprintf(buf, "%v", v)
+	}
+}
+
+// dumpString writes s quoted if it is printable text, or as its length and
+// hex digits otherwise.
+func dumpString(buf *bytes.Buffer, s string) {
+	if isText(s) {
+		buf.WriteString(strconv.Quote(s))
+		return
+	}
+	fmt.Fprintf(buf, "<%d bytes: %s>", len(s), hex.EncodeToString([]byte(s)))
+}
+
+// isText reports whether s is valid UTF-8 without control characters other
+// than tabs and line breaks.
+func isText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, c := range s {
+		if unicode.IsControl(c) && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// dumpIndent writes the indentation for depth levels of nesting.
+func dumpIndent(buf *bytes.Buffer, depth int) {
+	for range depth {
+		buf.WriteString("  ")
+	}
+}
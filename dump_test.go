@@ -0,0 +1,78 @@
+package bencode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "Nested",
+			in:   "d4:infod6:lengthi12e6:pieces4:\x01\x02\xfe\x7fe4:tagsl1:b1:ae5:emptyle4:nonede3:bigi123456789012345678901234567890ee",
+			want: `{
+  "info": {
+    "length": 12,
+    "pieces": <4 bytes: 0102fe7f>
+  },
+  "tags": [
+    "b",
+    "a"
+  ],
+  "empty": [],
+  "none": {},
+  "big": 123456789012345678901234567890
+}
+`,
+		},
+		{name: "Integer", in: "i-42e", want: "-42\n"},
+		{name: "Text", in: "12:caf\u00e9 \"n\u00f8\"\n", want: "\"caf\u00e9 \\\"n\u00f8\\\"\\n\"\n"},
+		{name: "Binary Key", in: "d2:\x00\x01i1ee", want: "{\n  <2 bytes: 0001>: 1\n}\n"},
+		{name: "Empty String", in: "0:", want: "\"\"\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Dump([]byte(tc.in), &buf); err != nil {
+				t.Fatalf("Dump() error = %v", err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("Dump() got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDumpError(t *testing.T) {
+	for _, in := range []string{"", "l4:spam", "di1ei2ee"} {
+		var buf bytes.Buffer
+		if err := Dump([]byte(in), &buf); err == nil {
+			t.Errorf("Dump(%q) returned no error", in)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Dump(%q) wrote %q before failing", in, buf.String())
+		}
+	}
+}
+
+func TestDumpTorrent(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Dump([]byte(multiTrackerTorrent), &buf); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	for _, want := range []string{
+		`  "announce-list": [` + "\n    [\n",
+		`        "length": 2048,`,
+		`    "pieces": "01234567890123456789"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Dump() output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,678 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// startDetectingCyclesAfter is the depth of nested pointers, maps and slices
+// beyond which the writer starts checking for cycles. Checking only deep values
+// keeps the cost off ordinary ones, as in encoding/json.
+const startDetectingCyclesAfter = 1000
+
+// writer is a buffered writer that provides methods for encoding bencode values.
+type writer struct {
+	w *bufio.Writer
+
+	// ptrLevel is the current depth of nested pointers, maps and slices, and
+	// ptrSeen holds those being encoded once that depth is large enough that
+	// the value may contain itself.
+	ptrLevel uint
+	ptrSeen  map[any]struct{}
+
+	// tagName is the struct tag key that field keys and options are read from.
+	tagName string
+
+	// allowedKeys holds, for each struct type with an allowlist, the only
+	// keys that are written for it.
+	allowedKeys map[reflect.Type]map[string]bool
+
+	// canonical guarantees canonical output, by ignoring OrderedStruct and
+	// rejecting a RawMessage that is not itself canonical.
+	canonical bool
+
+	// stringers writes errors, and fmt.Stringers that could not otherwise be
+	// encoded, as strings of their text.
+	stringers bool
+
+	// sizeOnly skips writing the contents of strings, which are instead
+	// counted in skipped, for EncodedLen.
+	sizeOnly bool
+	skipped  int64
+}
+
+// writerPool holds writers for reuse by AppendBencode, so that encoding a small
+// value does not allocate a new bufio.Writer each time.
+var writerPool = sync.Pool{
+	New: func() any { return newWriter(nil) },
+}
+
+// release resets w and returns it to writerPool.
+func (w *writer) release() {
+	w.w.Reset(nil)
+	w.allowedKeys = nil
+	w.tagName = defaultTagName
+	w.canonical = false
+	w.stringers = false
+	w.sizeOnly = false
+	w.skipped = 0
+	w.ptrLevel = 0
+	clear(w.ptrSeen)
+	writerPool.Put(w)
+}
+
+// appendBuffer is an io.Writer that appends to a byte slice.
+type appendBuffer struct {
+	b []byte
+}
+
+func (a *appendBuffer) Write(p []byte) (int, error) {
+	a.b = append(a.b, p...)
+	return len(p), nil
+}
+
+// byteCounter is an io.Writer that only counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// newWriter creates a new writer from an io.Writer.
+// If the writer is already a *bufio.Writer, it will be used directly.
+func newWriter(w io.Writer) *writer {
+	if bw, ok := w.(*bufio.Writer); ok {
+		return &writer{w: bw, tagName: defaultTagName}
+	}
+	return &writer{w: bufio.NewWriter(w), tagName: defaultTagName}
+}
+
+// encode writes the bencode representation of v.
+func (w *writer) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: cannot marshal nil")
+	}
+
+	// Pointers and interfaces are followed to the value they hold before
+	// anything else, as on the decode side, so that everything below sees
+	// only concrete values. Bencode has no null value, so a nil pointer or
+	// interface is an error here; struct fields with the "omitempty" option
+	// are left out before reaching this point instead.
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot marshal nil %s", v.Type())
+		}
+		if v.Kind() == reflect.Pointer {
+			if err := w.enter(v); err != nil {
+				return err
+			}
+			defer w.leave(v)
+		}
+		v = v.Elem()
+	}
+
+	// RawMessage is written verbatim, StringReader is streamed from its
+	// reader, and time.Time, time.Duration, big.Int and Number are integers on
+	// the wire, so they must be handled before the generic kind dispatch below.
+	switch v.Type() {
+	case rawMessageType:
+		if v.Len() == 0 {
+			return fmt.Errorf("bencode: cannot marshal empty RawMessage")
+		}
+		if w.canonical {
+			if err := checkCanonical(v.Bytes()); err != nil {
+				return err
+			}
+		}
+		_, err := w.w.Write(v.Bytes())
+		return err
+
+	case orderedDictType:
+		d := v.Interface().(OrderedDict)
+		return w.encodeOrderedDict(&d)
+
+	case stringReaderType:
+		sr := v.Interface().(StringReader)
+		return w.encodeStringReader(&sr)
+
+	case timeType:
+		return w.encodeInt(v.Interface().(time.Time).Unix())
+	case durationType:
+		secs, err := durationSeconds(v)
+		if err != nil {
+			return err
+		}
+		return w.encodeInt(secs)
+	case bigIntType:
+		b := v.Interface().(big.Int)
+		return w.encodeBigInt(&b)
+	case numberType:
+		b, err := Number(v.String()).BigInt()
+		if err != nil {
+			return err
+		}
+		return w.encodeBigInt(b)
+	}
+
+	// Types implementing encoding.TextMarshaler, such as net.IP, are written
+	// as bencode strings of their text form.
+	if tm, ok := textMarshaler(v); ok {
+		text, err := tm.MarshalText()
+		if err != nil {
+			return err
+		}
+		return w.encodeString(string(text))
+	}
+
+	// With UseStringer, errors and otherwise unsupported fmt.Stringers are
+	// written as strings of their text.
+	if w.stringers {
+		if text, ok := stringerText(v); ok {
+			return w.encodeString(text)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return w.encodeString(v.String())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return w.encodeInt(v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return w.encodeUint(v.Uint())
+
+	case reflect.Slice:
+		// Byte slices are written as bencode strings rather than lists of integers.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return w.encodeString(string(v.Bytes()))
+		}
+		if err := w.enter(v); err != nil {
+			return err
+		}
+		defer w.leave(v)
+		return w.encodeList(v)
+
+	case reflect.Array:
+		// Byte arrays, such as a [20]byte hash, are written as bencode
+		// strings, like byte slices.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return w.encodeString(string(b))
+		}
+		return w.encodeList(v)
+
+	case reflect.Map:
+		if err := w.enter(v); err != nil {
+			return err
+		}
+		defer w.leave(v)
+		return w.encodeDict(v)
+
+	case reflect.Struct:
+		return w.encodeStruct(v)
+
+	default:
+		return fmt.Errorf("bencode: unsupported type for marshaling: %s", v.Kind())
+	}
+}
+
+// enter records that the pointer, map or slice v is being encoded, returning an
+// *UnsupportedValueError if it already is, meaning that v contains itself. Each
+// successful call must be followed by a call to leave.
+func (w *writer) enter(v reflect.Value) error {
+	w.ptrLevel++
+	if w.ptrLevel <= startDetectingCyclesAfter {
+		return nil
+	}
+
+	key := cycleKey(v)
+	if _, ok := w.ptrSeen[key]; ok {
+		w.ptrLevel--
+		return &UnsupportedValueError{Value: v, Str: "encountered a cycle"}
+	}
+	if w.ptrSeen == nil {
+		w.ptrSeen = make(map[any]struct{})
+	}
+	w.ptrSeen[key] = struct{}{}
+	return nil
+}
+
+// leave records that encoding v, previously passed to enter, has finished.
+func (w *writer) leave(v reflect.Value) {
+	if w.ptrLevel > startDetectingCyclesAfter {
+		delete(w.ptrSeen, cycleKey(v))
+	}
+	w.ptrLevel--
+}
+
+// cycleKey identifies the pointer, map or slice v for cycle detection. A slice
+// is identified by its length as well as its first element, since a slice and
+// a shorter one sharing its backing array are different values.
+func cycleKey(v reflect.Value) any {
+	if v.Kind() == reflect.Slice {
+		return struct {
+			ptr uintptr
+			len int
+		}{v.Pointer(), v.Len()}
+	}
+	return v.Pointer()
+}
+
+// encodeString writes a string.
+// Format: <length>:<contents>
+func (w *writer) encodeString(s string) error {
+	w.w.WriteString(strconv.Itoa(len(s)))
+	w.w.WriteByte(':')
+	if w.sizeOnly {
+		w.skipped += int64(len(s))
+		return nil
+	}
+	_, err := w.w.WriteString(s)
+	return err
+}
+
+// encodeStringReader writes a string whose contents are copied from a reader.
+// Format: <length>:<contents>
+func (w *writer) encodeStringReader(sr *StringReader) error {
+	if sr.Len < 0 {
+		return fmt.Errorf("bencode: invalid StringReader length %d", sr.Len)
+	}
+	w.w.WriteString(strconv.FormatInt(sr.Len, 10))
+	w.w.WriteByte(':')
+	if w.sizeOnly {
+		// The contents are not read, so that the reader is left for Marshal.
+		w.skipped += sr.Len
+		return nil
+	}
+	n, err := io.CopyN(w.w, sr.R, sr.Len)
+	if err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("bencode: StringReader ended after %d of %d bytes", n, sr.Len)
+		}
+		return fmt.Errorf("bencode: failed to copy StringReader contents: %w", err)
+	}
+	return nil
+}
+
+// encodeInt writes a signed integer.
+// Format: i<integer>e
+func (w *writer) encodeInt(i int64) error {
+	w.w.WriteByte('i')
+	w.w.WriteString(strconv.FormatInt(i, 10))
+	return w.w.WriteByte('e')
+}
+
+// encodeUint writes an unsigned integer.
+// Format: i<integer>e
+func (w *writer) encodeUint(i uint64) error {
+	w.w.WriteByte('i')
+	w.w.WriteString(strconv.FormatUint(i, 10))
+	return w.w.WriteByte('e')
+}
+
+// encodeBigInt writes an arbitrary precision integer.
+// Format: i<integer>e
+func (w *writer) encodeBigInt(b *big.Int) error {
+	w.w.WriteByte('i')
+	w.w.WriteString(b.String())
+	return w.w.WriteByte('e')
+}
+
+// encodeList writes the elements of a slice as a list.
+// Format: l<value1><value2>...e
+func (w *writer) encodeList(v reflect.Value) error {
+	w.w.WriteByte('l')
+	for i := 0; i < v.Len(); i++ {
+		if err := w.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return w.w.WriteByte('e')
+}
+
+// encodeDict writes a map as a dictionary with its keys in sorted order.
+// Keys of string kind are used as they are, while integer keys and keys
+// implementing encoding.TextMarshaler are converted to strings first, as in
+// encoding/json. The keys are then sorted by their string form.
+// Format: d<key1><value1><key2><value2>...e
+func (w *writer) encodeDict(v reflect.Value) error {
+	kt := v.Type().Key()
+	if kt.Kind() == reflect.String {
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		w.w.WriteByte('d')
+		for _, key := range keys {
+			if err := w.encodeString(key.String()); err != nil {
+				return err
+			}
+			if err := w.encode(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		return w.w.WriteByte('e')
+	}
+
+	if !isIntKind(kt.Kind()) && !kt.Implements(textMarshalerType) {
+		return fmt.Errorf("bencode: unsupported map key type for marshaling: %s", kt)
+	}
+
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := mapKeyString(iter.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	w.w.WriteByte('d')
+	for i, e := range entries {
+		if i > 0 && e.key == entries[i-1].key {
+			return fmt.Errorf("bencode: map of type %s has two keys encoded as %q", v.Type(), e.key)
+		}
+		if err := w.encodeString(e.key); err != nil {
+			return err
+		}
+		if err := w.encode(e.value); err != nil {
+			return err
+		}
+	}
+	return w.w.WriteByte('e')
+}
+
+// mapKeyString returns the dictionary key for the map key k, which is an
+// integer or implements encoding.TextMarshaler.
+func mapKeyString(k reflect.Value) (string, error) {
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", fmt.Errorf("bencode: cannot marshal nil %s map key", k.Type())
+		}
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	default:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+}
+
+// isIntKind reports whether k is a signed or unsigned integer kind.
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// encodeOrderedDict writes an OrderedDict as a dictionary with its keys in
+// sorted order. The keys are only sorted if they are not in order already.
+// Format: d<key1><value1><key2><value2>...e
+func (w *writer) encodeOrderedDict(d *OrderedDict) error {
+	w.w.WriteByte('d')
+	for _, key := range d.sortedKeys() {
+		if err := w.encodeString(key); err != nil {
+			return err
+		}
+		if err := w.encode(reflect.ValueOf(d.values[key])); err != nil {
+			return err
+		}
+	}
+	return w.w.WriteByte('e')
+}
+
+// encodeStruct writes the exported fields of a struct as a dictionary. Keys
+// are taken from the bencode struct tag, or the field name if there is none,
+// and are written in sorted order unless the struct implements OrderedStruct.
+// Fields of embedded structs are promoted into the same dictionary, as are the
+// entries of a field with the "extra" option. Fields with the "omitempty"
+// option are left out when they hold an empty value, and integer and boolean
+// fields with the "string" option are written as strings. If the struct type
+// has an allowlist, only the keys it contains are written.
+func (w *writer) encodeStruct(v reflect.Value) error {
+	type structField struct {
+		key    string
+		value  reflect.Value
+		quoted bool
+	}
+
+	allowed, restricted := w.allowedKeys[v.Type()]
+
+	var fields []structField
+	var extra reflect.Value
+	for _, f := range structFields(v.Type(), w.tagName) {
+		// Decode timestamps are local metadata, not part of the encoding.
+		if f.opts.Contains("decodedAt") {
+			continue
+		}
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// The field is promoted through a nil embedded pointer.
+			continue
+		}
+		if f.opts.Contains("extra") {
+			extra = fv
+			continue
+		}
+		if f.opts.Contains("omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		if restricted && !allowed[f.key] {
+			continue
+		}
+		quoted := f.opts.Contains("string") && quotable(fv.Type())
+		fields = append(fields, structField{key: f.key, value: fv, quoted: quoted})
+	}
+
+	// The entries of an "extra" field are written alongside the other fields,
+	// so that keys captured when decoding survive a round trip. A field with
+	// the same key takes precedence.
+	if extra.Kind() == reflect.Map && extra.Len() > 0 {
+		if extra.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("bencode: extra field of %s must be a map with string keys", v.Type())
+		}
+		taken := make(map[string]bool, len(fields))
+		for _, f := range fields {
+			taken[f.key] = true
+		}
+		iter := extra.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if taken[key] || restricted && !allowed[key] {
+				continue
+			}
+			fields = append(fields, structField{key: key, value: iter.Value()})
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	if order, ok := keyOrder(v); ok && !w.canonical {
+		rank := make(map[string]int, len(order))
+		for i, key := range order {
+			if _, dup := rank[key]; !dup {
+				rank[key] = i
+			}
+		}
+		// Keys without a rank keep their sorted order after the ranked ones.
+		sort.SliceStable(fields, func(i, j int) bool {
+			ri, iok := rank[fields[i].key]
+			rj, jok := rank[fields[j].key]
+			return iok && (!jok || ri < rj)
+		})
+	}
+
+	w.w.WriteByte('d')
+	for _, f := range fields {
+		if err := w.encodeString(f.key); err != nil {
+			return err
+		}
+		if f.quoted {
+			if err := w.encodeQuoted(f.value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.encode(f.value); err != nil {
+			return err
+		}
+	}
+	return w.w.WriteByte('e')
+}
+
+// durationSeconds returns the time.Duration v as a number of seconds, which is
+// how durations are encoded. A duration with a fractional second cannot be
+// encoded without losing precision, so it is an *UnsupportedValueError.
+func durationSeconds(v reflect.Value) (int64, error) {
+	d := time.Duration(v.Int())
+	if d%time.Second != 0 {
+		return 0, &UnsupportedValueError{Value: v, Str: "duration " + d.String() + " is not a whole number of seconds"}
+	}
+	return int64(d / time.Second), nil
+}
+
+// encodeQuoted writes v, the value of a field with the "string" option, as a
+// string of its decimal or boolean text rather than as an integer. A
+// time.Duration is written as a number of seconds, as it is otherwise.
+func (w *writer) encodeQuoted(v reflect.Value) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot marshal nil %s", v.Type())
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Type() == durationType:
+		secs, err := durationSeconds(v)
+		if err != nil {
+			return err
+		}
+		return w.encodeString(strconv.FormatInt(secs, 10))
+	case v.Kind() == reflect.Bool:
+		return w.encodeString(strconv.FormatBool(v.Bool()))
+	case v.CanInt():
+		return w.encodeString(strconv.FormatInt(v.Int(), 10))
+	default:
+		return w.encodeString(strconv.FormatUint(v.Uint(), 10))
+	}
+}
+
+// isEmptyValue reports whether v is empty for the purposes of the "omitempty"
+// tag option: false, 0, a nil pointer or interface, or an empty string, slice
+// or map.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// checkCanonical returns an error unless raw is exactly one value in
+// canonical form.
+func checkCanonical(raw []byte) error {
+	r := newReader(bytes.NewReader(raw))
+	r.canonical = true
+	r.maxIntDigits = 0
+	if _, err := r.decode(); err != nil {
+		return fmt.Errorf("bencode: RawMessage is not canonical: %w", err)
+	}
+	if r.offset != int64(len(raw)) {
+		return fmt.Errorf("bencode: RawMessage has %d bytes after its value", int64(len(raw))-r.offset)
+	}
+	return nil
+}
+
+// keyOrder returns the key order of v if it implements OrderedStruct, either
+// directly or, when v is addressable, through a pointer.
+func keyOrder(v reflect.Value) ([]string, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if o, ok := v.Interface().(OrderedStruct); ok {
+		return o.KeyOrder(), true
+	}
+	if v.CanAddr() {
+		if o, ok := v.Addr().Interface().(OrderedStruct); ok {
+			return o.KeyOrder(), true
+		}
+	}
+	return nil, false
+}
+
+// stringerText returns the text of v for UseStringer: the result of its Error
+// method if it implements error, or otherwise of its String method if it
+// implements fmt.Stringer and is of a kind that has no encoding of its own. As
+// with textMarshaler, the methods may be on a pointer to an addressable v.
+func stringerText(v reflect.Value) (string, bool) {
+	if !v.CanInterface() {
+		return "", false
+	}
+	i := v.Interface()
+	if v.CanAddr() {
+		if _, ok := i.(error); !ok {
+			if _, ok := i.(fmt.Stringer); !ok {
+				i = v.Addr().Interface()
+			}
+		}
+	}
+	if err, ok := i.(error); ok {
+		return err.Error(), true
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Array, reflect.Slice, reflect.Map, reflect.Struct:
+		return "", false
+	}
+	if s, ok := i.(fmt.Stringer); ok {
+		return s.String(), true
+	}
+	return "", false
+}
+
+// textMarshaler returns v as an encoding.TextMarshaler if it implements the
+// interface, either directly or, when v is addressable, through a pointer.
+func textMarshaler(v reflect.Value) (encoding.TextMarshaler, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+		return tm, true
+	}
+	if v.CanAddr() {
+		tm, ok := v.Addr().Interface().(encoding.TextMarshaler)
+		return tm, ok
+	}
+	return nil, false
+}
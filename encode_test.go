@@ -0,0 +1,963 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+type marshalTest struct {
+	name    string
+	in      any
+	want    string
+	wantErr bool
+}
+
+var marshalTests = []marshalTest{
+	{name: "Simple String", in: "spam", want: "4:spam"},
+	{name: "Empty String", in: "", want: "0:"},
+	{name: "Simple Integer", in: 42, want: "i42e"},
+	{name: "Negative Integer", in: -42, want: "i-42e"},
+	{name: "Unsigned Integer", in: uint8(255), want: "i255e"},
+	{name: "Big Integer", in: new(big.Int).Lsh(big.NewInt(1), 100), want: "i1267650600228229401496703205376e"},
+	{name: "Byte Slice", in: []byte("\x01\x02"), want: "2:\x01\x02"},
+	{name: "Byte Array", in: [20]byte{0: 0xde, 19: 0xff}, want: "20:\xde" + strings.Repeat("\x00", 18) + "\xff"},
+	{name: "Empty Byte Array", in: [0]byte{}, want: "0:"},
+	{name: "Int Array", in: [3]int{1, -2, 3}, want: "li1ei-2ei3ee"},
+	{name: "Nested Array", in: [2][2]string{{"a", "b"}, {"c", "d"}}, want: "ll1:a1:bel1:c1:dee"},
+	{name: "Simple List", in: []any{"spam", 42}, want: "l4:spami42ee"},
+	{name: "Empty List", in: []int{}, want: "le"},
+	{name: "Sorted Dictionary", in: map[string]any{"hello": 42, "foo": "bar"}, want: "d3:foo3:bar5:helloi42ee"},
+	{name: "Empty Dictionary", in: map[string]int{}, want: "de"},
+	{
+		name: "Struct With Tags",
+		in: struct {
+			Name  string `bencode:"name"`
+			Count int    `bencode:"count"`
+			List  []int
+			priv  string
+		}{Name: "x", Count: 1, List: []int{1, 2}, priv: "hidden"},
+		want: "d4:Listli1ei2ee5:counti1e4:name1:xe",
+	},
+	{name: "Pointer", in: ptr(7), want: "i7e"},
+	{name: "RawMessage", in: map[string]RawMessage{"a": RawMessage("li1ee")}, want: "d1:ali1eee"},
+	{name: "Empty RawMessage", in: RawMessage(nil), wantErr: true},
+	{
+		name: "Ignored Field",
+		in: struct {
+			Foo string `bencode:"foo"`
+			Bar string `bencode:"-"`
+		}{Foo: "a", Bar: "b"},
+		want: "d3:foo1:ae",
+	},
+	{
+		name: "Embedded Struct",
+		in:   embeddedInfo{Common: Common{Name: "foo", Comment: "inner"}, Length: 3, Comment: "outer"},
+		want: "d7:comment5:outer6:lengthi3e4:name3:fooe",
+	},
+	{name: "Nil Embedded Pointer", in: embeddedPointerInfo{Length: 3}, want: "d6:lengthi3ee"},
+	{name: "Nil", in: nil, wantErr: true},
+	{name: "Nil Pointer", in: (*int)(nil), wantErr: true},
+	{name: "Unsupported Type", in: 1.5, wantErr: true},
+	{name: "Int Map Key", in: map[int]string{10: "b", 9: "a", -1: "c"}, want: "d2:-11:c2:101:b1:91:ae"},
+	{name: "Uint Map Key", in: map[uint8]int{255: 1}, want: "d3:255i1ee"},
+	{name: "TextMarshaler Map Key", in: map[version]int{{Major: 10}: 1, {Major: 9, Minor: 1}: 2}, want: "d4:10.0i1e3:9.1i2ee"},
+	{name: "Unsupported Map Key", in: map[float64]string{1.5: "a"}, wantErr: true},
+	{name: "Struct Map Key", in: map[struct{ A int }]string{{1}: "a"}, wantErr: true},
+}
+
+func TestMarshal(t *testing.T) {
+	for _, tc := range marshalTests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Marshal(tc.in)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Marshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if !tc.wantErr && string(got) != tc.want {
+				t.Errorf("Marshal() got = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncoderConsecutive(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+
+	for _, v := range []any{1, 2, "spam"} {
+		if err := e.Encode(v); err != nil {
+			t.Fatalf("Encode(%v) error = %v", v, err)
+		}
+	}
+
+	if got, want := buf.String(), "i1ei2e4:spam"; got != want {
+		t.Errorf("Encoder output = %q, want %q", got, want)
+	}
+}
+
+func TestTimeRoundTrip(t *testing.T) {
+	type Torrent struct {
+		CreationDate time.Time     `bencode:"creation date"`
+		Interval     time.Duration `bencode:"interval"`
+	}
+
+	const encoded = "d13:creation datei1700000000e8:intervali1800ee"
+	want := Torrent{
+		CreationDate: time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC),
+		Interval:     30 * time.Minute,
+	}
+
+	var got Torrent
+	if err := Unmarshal([]byte(encoded), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	out, err := Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != encoded {
+		t.Errorf("Marshal() got = %q, want %q", out, encoded)
+	}
+}
+
+// genericValue holds a random value in the form decoding into an any
+// produces, for use with testing/quick.
+type genericValue struct {
+	v any
+}
+
+// Generate implements quick.Generator, nesting lists and dictionaries at most
+// a few levels deep so that values stay small.
+func (genericValue) Generate(rand *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(genericValue{randomGeneric(rand, size, 3)})
+}
+
+func randomGeneric(rand *rand.Rand, size, depth int) any {
+	kinds := 4
+	if depth == 0 {
+		kinds = 2
+	}
+	switch rand.Intn(kinds) {
+	case 0:
+		b := make([]byte, rand.Intn(size+1))
+		rand.Read(b)
+		return string(b)
+	case 1:
+		// Cover the extremes as well as small values.
+		return rand.Int63() - rand.Int63() - int64(rand.Intn(2))
+	case 2:
+		list := make([]any, rand.Intn(size/4+1))
+		for i := range list {
+			list[i] = randomGeneric(rand, size, depth-1)
+		}
+		return list
+	default:
+		dict := make(map[string]any)
+		for range rand.Intn(size/4 + 1) {
+			key := make([]byte, rand.Intn(8))
+			rand.Read(key)
+			dict[string(key)] = randomGeneric(rand, size, depth-1)
+		}
+		return dict
+	}
+}
+
+func TestMarshalRoundTripQuick(t *testing.T) {
+	roundTrip := func(in genericValue) bool {
+		data, err := Marshal(in.v)
+		if err != nil {
+			t.Logf("Marshal(%#v) error = %v", in.v, err)
+			return false
+		}
+		var out any
+		if err := Unmarshal(data, &out); err != nil {
+			t.Logf("Unmarshal(%q) error = %v", data, err)
+			return false
+		}
+		if !reflect.DeepEqual(out, in.v) {
+			t.Logf("Unmarshal(%q) got = %#v, want %#v", data, out, in.v)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDurationOverflow(t *testing.T) {
+	var d time.Duration
+	if err := Unmarshal([]byte("i9223372036854775807e"), &d); err == nil {
+		t.Error("expected an error for a duration that overflows")
+	}
+}
+
+func TestMarshalFractionalDuration(t *testing.T) {
+	// Durations are encoded in whole seconds, so one with a fractional second
+	// is an error rather than being silently truncated.
+	for _, in := range []any{
+		1500 * time.Millisecond,
+		[]time.Duration{time.Second, -time.Nanosecond},
+		struct {
+			Interval time.Duration `bencode:"interval,string"`
+		}{Interval: 1500 * time.Millisecond},
+	} {
+		_, err := Marshal(in)
+		var valueErr *UnsupportedValueError
+		if !errors.As(err, &valueErr) {
+			t.Errorf("Marshal(%v) error = %v, want *UnsupportedValueError", in, err)
+		}
+	}
+
+	if got, err := Marshal(-90 * time.Second); err != nil || string(got) != "i-90e" {
+		t.Errorf("Marshal() = %q, %v, want %q", got, err, "i-90e")
+	}
+}
+
+func TestEncodeStringReader(t *testing.T) {
+	const size = 1 << 20
+	pieces := StringReader{Len: size, R: io.LimitReader(zeroReader{}, size)}
+
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(map[string]any{"length": size, "pieces": pieces})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	want := "d6:lengthi1048576e6:pieces1048576:" + strings.Repeat("\x00", size) + "e"
+	if buf.String() != want {
+		t.Errorf("Encode() output has length %d, want %d", buf.Len(), len(want))
+	}
+
+	short := StringReader{Len: 10, R: strings.NewReader("abc")}
+	if _, err := Marshal(short); err == nil {
+		t.Error("expected an error for a StringReader that ends early")
+	}
+}
+
+// zeroReader is an io.Reader that produces an endless stream of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestEncoderSetAllowedKeys(t *testing.T) {
+	type file struct {
+		Length int64  `bencode:"length"`
+		MD5    string `bencode:"md5sum"`
+		Path   string `bencode:"path"`
+	}
+	type info struct {
+		Name      string `bencode:"name"`
+		Files     []file `bencode:"files"`
+		CreatedBy string `bencode:"created by"`
+		Private   int    `bencode:"private"`
+		Comment   string `bencode:"comment"`
+	}
+
+	v := info{
+		Name:      "example",
+		Files:     []file{{Length: 7, MD5: "abc", Path: "a.txt"}},
+		CreatedBy: "tool/1.0",
+		Private:   1,
+		Comment:   "secret",
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetAllowedKeys(reflect.TypeFor[info](), map[string]bool{"private": true, "name": true, "files": true})
+	e.SetAllowedKeys(reflect.TypeFor[file](), map[string]bool{"path": true, "length": true})
+	if err := e.Encode(v); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := "d5:filesld6:lengthi7e4:path5:a.txtee4:name7:example7:privatei1ee"
+	if got := buf.String(); got != want {
+		t.Errorf("Encode() got = %q, want %q", got, want)
+	}
+
+	// Removing the allowlist writes every key again.
+	buf.Reset()
+	e.SetAllowedKeys(reflect.TypeFor[info](), nil)
+	e.SetAllowedKeys(reflect.TypeFor[file](), nil)
+	if err := e.Encode(v); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "7:comment6:secret") {
+		t.Errorf("Encode() got = %q after removing the allowlist, want all keys", buf.String())
+	}
+}
+
+func TestMarshalCycle(t *testing.T) {
+	type node struct {
+		Next *node `bencode:"next"`
+	}
+
+	slice := make([]any, 1)
+	slice[0] = slice
+
+	m := map[string]any{}
+	m["self"] = m
+
+	n := &node{}
+	n.Next = n
+
+	testCases := []struct {
+		name string
+		in   any
+	}{
+		{name: "Slice", in: slice},
+		{name: "Map", in: m},
+		{name: "Pointer", in: n},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Marshal(tc.in)
+			var unsupported *UnsupportedValueError
+			if !errors.As(err, &unsupported) {
+				t.Fatalf("Marshal() error = %v, want *UnsupportedValueError", err)
+			}
+			if want := "bencode: unsupported value: encountered a cycle"; err.Error() != want {
+				t.Errorf("Marshal() error = %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestMarshalDeepAcyclic(t *testing.T) {
+	// Values nested beyond the cycle detection depth still encode, including
+	// ones that refer to the same value more than once without a cycle.
+	shared := []any{int64(1)}
+	var v any = []any{shared, shared}
+	for i := 0; i < 2*startDetectingCyclesAfter; i++ {
+		v = []any{v}
+	}
+
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	depth := 2*startDetectingCyclesAfter + 1
+	want := strings.Repeat("l", depth) + "li1eeli1ee" + strings.Repeat("e", depth)
+	if string(got) != want {
+		t.Errorf("Marshal() got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestAppendBencode(t *testing.T) {
+	dst := []byte("prefix:")
+	got, err := AppendBencode(dst, map[string]any{"interval": 1800, "peers": ""})
+	if err != nil {
+		t.Fatalf("AppendBencode() error = %v", err)
+	}
+	if want := "prefix:d8:intervali1800e5:peers0:e"; string(got) != want {
+		t.Errorf("AppendBencode() got = %q, want %q", got, want)
+	}
+
+	// On error, dst is returned unchanged and the pooled writer is left clean.
+	got, err = AppendBencode(dst, []any{"x", make(chan int)})
+	if err == nil {
+		t.Fatal("AppendBencode() of an unsupported type returned no error")
+	}
+	if string(got) != "prefix:" {
+		t.Errorf("AppendBencode() got = %q on error, want %q", got, "prefix:")
+	}
+	if got, err := AppendBencode(nil, 1); err != nil || string(got) != "i1e" {
+		t.Errorf("AppendBencode() after an error got = %q, %v, want %q", got, err, "i1e")
+	}
+}
+
+func TestEncodedLen(t *testing.T) {
+	for _, tt := range marshalTests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := EncodedLen(tt.in)
+			data, marshalErr := Marshal(tt.in)
+			if (err != nil) != (marshalErr != nil) {
+				t.Fatalf("EncodedLen() error = %v, Marshal() error = %v", err, marshalErr)
+			}
+			if err == nil && n != len(data) {
+				t.Errorf("EncodedLen() = %d, want len(Marshal()) = %d", n, len(data))
+			}
+		})
+	}
+
+	// A StringReader is counted from its Len without being read.
+	r := strings.NewReader("abcdef")
+	n, err := EncodedLen(map[string]any{"pieces": StringReader{Len: 6, R: r}, "private": 1})
+	if err != nil {
+		t.Fatalf("EncodedLen() error = %v", err)
+	}
+	if want := len("d6:pieces6:abcdef7:privatei1ee"); n != want {
+		t.Errorf("EncodedLen() = %d, want %d", n, want)
+	}
+	if r.Len() != 6 {
+		t.Errorf("EncodedLen() read %d bytes of the StringReader", 6-r.Len())
+	}
+}
+
+func BenchmarkMarshalReuse(b *testing.B) {
+	type response struct {
+		Interval int    `bencode:"interval"`
+		Peers    []byte `bencode:"peers"`
+	}
+	v := response{Interval: 1800, Peers: make([]byte, 60)}
+
+	b.Run("Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Marshal(v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("AppendBencode", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			if buf, err = AppendBencode(buf[:0], v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestMarshalNil(t *testing.T) {
+	type Info struct {
+		Name    string         `bencode:"name"`
+		Files   []string       `bencode:"files"`
+		Extra   map[string]int `bencode:"extra"`
+		Pieces  []byte         `bencode:"pieces"`
+		Private *int           `bencode:"private,omitempty"`
+		Source  *string        `bencode:"source,omitempty"`
+	}
+
+	testCases := []struct {
+		name    string
+		in      any
+		want    string
+		wantErr bool
+	}{
+		{name: "Nil Map", in: map[string]any(nil), want: "de"},
+		{name: "Nil Slice", in: []int(nil), want: "le"},
+		{name: "Nil Byte Slice", in: []byte(nil), want: "0:"},
+		{
+			name: "Nil Fields",
+			in:   Info{Name: "foo", Source: ptr("src")},
+			want: "d5:extrade5:filesle4:name3:foo6:pieces0:6:source3:srce",
+		},
+		{name: "Pointer", in: ptr(5), want: "i5e"},
+		{name: "Pointer Chain", in: ptr(ptr(ptr("x"))), want: "1:x"},
+		{name: "Pointer In Interface", in: []any{ptr(1), ptr[any](ptr("a"))}, want: "li1e1:ae"},
+		{name: "Pointer To RawMessage", in: ptr(RawMessage("i1e")), want: "i1e"},
+		{
+			name: "Set Pointer Field",
+			in:   Info{Name: "foo", Private: ptr(1)},
+			want: "d5:extrade5:filesle4:name3:foo6:pieces0:7:privatei1ee",
+		},
+		{name: "Nil Pointer", in: (*int)(nil), wantErr: true},
+		{name: "Nil Pointer In Chain", in: ptr(ptr((*int)(nil))), wantErr: true},
+		{name: "Nil Pointer Field", in: struct{ P *int }{}, wantErr: true},
+		{name: "Nil Interface Element", in: []any{nil}, wantErr: true},
+		{
+			name: "Omitempty",
+			in: struct {
+				A int    `bencode:"a,omitempty"`
+				B string `bencode:"b,omitempty"`
+				C []int  `bencode:"c,omitempty"`
+				D int    `bencode:"d"`
+			}{},
+			want: "d1:di0ee",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Marshal(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Marshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && string(got) != tc.want {
+				t.Errorf("Marshal() got = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarshalTopLevelNil(t *testing.T) {
+	// Bencode has no null, so a nil with no zero value to stand in for it
+	// fails at the top level rather than producing empty output.
+	testCases := []struct {
+		name    string
+		in      any
+		wantErr string
+	}{
+		{name: "Untyped Nil", in: nil, wantErr: "bencode: cannot marshal nil"},
+		{name: "Nil Struct Pointer", in: (*legacyInfo)(nil), wantErr: "bencode: cannot marshal nil *bencode.legacyInfo"},
+		{name: "Pointer To Nil Pointer", in: new(*int), wantErr: "bencode: cannot marshal nil *int"},
+		{name: "Pointer To Nil Interface", in: new(any), wantErr: "bencode: cannot marshal nil interface {}"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Marshal(tc.in)
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("Marshal() = %q, %v, want error %q", got, err, tc.wantErr)
+			}
+			if got != nil {
+				t.Errorf("Marshal() got = %q, want nil", got)
+			}
+
+			if _, err := MarshalCanonical(tc.in); err == nil {
+				t.Error("MarshalCanonical() error = nil")
+			}
+
+			dst := []byte("i1e")
+			if got, err := AppendBencode(dst, tc.in); err == nil || string(got) != "i1e" {
+				t.Errorf("AppendBencode() = %q, %v, want dst unchanged and an error", got, err)
+			}
+
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(tc.in); err == nil || buf.Len() != 0 {
+				t.Errorf("Encode() wrote %q, error = %v, want nothing written and an error", buf.String(), err)
+			}
+		})
+	}
+}
+
+// legacyInfo is written with its keys in the order of an old producer.
+type legacyInfo struct {
+	Name        string `bencode:"name"`
+	PieceLength int    `bencode:"piece length"`
+	Length      int    `bencode:"length"`
+	Private     int    `bencode:"private,omitempty"`
+	Source      string `bencode:"source"`
+}
+
+func (legacyInfo) KeyOrder() []string {
+	return []string{"piece length", "name", "private", "length"}
+}
+
+// pointerOrdered implements OrderedStruct through a pointer receiver.
+type pointerOrdered struct {
+	A int `bencode:"a"`
+	B int `bencode:"b"`
+}
+
+func (*pointerOrdered) KeyOrder() []string {
+	return []string{"b", "a"}
+}
+
+// quotedPeer has fields with the "string" option, as in formats that wrap
+// numbers in strings.
+type quotedPeer struct {
+	Port     int           `bencode:"port,string"`
+	Uploaded uint64        `bencode:"uploaded,string"`
+	Seed     bool          `bencode:"seed,string"`
+	Interval time.Duration `bencode:"interval,string"`
+	Left     *int64        `bencode:"left,string,omitempty"`
+	Name     string        `bencode:"name,string"`
+	Count    int           `bencode:"count"`
+}
+
+func TestStringOption(t *testing.T) {
+	in := quotedPeer{
+		Port:     6881,
+		Uploaded: math.MaxUint64,
+		Seed:     true,
+		Interval: 30 * time.Minute,
+		Left:     ptr(int64(-1)),
+		Name:     "peer",
+		Count:    3,
+	}
+	want := "d5:counti3e8:interval4:18004:left2:-14:name4:peer4:port4:68814:seed4:true8:uploaded20:18446744073709551615e"
+
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != want {
+		t.Errorf("Marshal() got = %q, want %q", data, want)
+	}
+
+	var got quotedPeer
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("Unmarshal() got = %+v, want %+v", got, in)
+	}
+
+	// Values must be in the form Marshal writes, and fit the field.
+	testCases := []struct {
+		name string
+		in   string
+	}{
+		{name: "Integer Not Quoted", in: "d4:porti6881ee"},
+		{name: "Not A Number", in: "d4:port3:abce"},
+		{name: "Empty", in: "d4:port0:e"},
+		{name: "Leading Zero", in: "d4:port5:06881e"},
+		{name: "Plus Sign", in: "d4:port5:+6881e"},
+		{name: "Negative Unsigned", in: "d8:uploaded2:-1e"},
+		{name: "Overflow", in: "d8:uploaded20:18446744073709551616e"},
+		{name: "Bool Not Lowercase", in: "d4:seed4:TRUEe"},
+		{name: "Bool As Number", in: "d4:seed1:1e"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got quotedPeer
+			err := Unmarshal([]byte(tc.in), &got)
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+			}
+		})
+	}
+}
+
+func TestMarshalOrderedStruct(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{
+			name: "Custom Order",
+			in:   legacyInfo{Name: "n", PieceLength: 2, Length: 3, Private: 1, Source: "s"},
+			want: "d12:piece lengthi2e4:name1:n7:privatei1e6:lengthi3e6:source1:se",
+		},
+		{
+			name: "Omitted Key Skipped",
+			in:   legacyInfo{Name: "n", PieceLength: 2, Length: 3},
+			want: "d12:piece lengthi2e4:name1:n6:lengthi3e6:source0:e",
+		},
+		{
+			name: "Nested",
+			in: struct {
+				Info legacyInfo `bencode:"info"`
+				Z    int        `bencode:"z"`
+			}{Info: legacyInfo{Name: "n"}, Z: 1},
+			want: "d4:infod12:piece lengthi0e4:name1:n6:lengthi0e6:source0:e1:zi1ee",
+		},
+		{name: "Pointer Receiver", in: &pointerOrdered{A: 1, B: 2}, want: "d1:bi2e1:ai1ee"},
+		{name: "Pointer Receiver Not Addressable", in: pointerOrdered{A: 1, B: 2}, want: "d1:ai1e1:bi2ee"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Marshal(tc.in)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("Marshal() got = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// The output decodes as usual, but is not canonical.
+	out, _ := Marshal(legacyInfo{Name: "n", PieceLength: 2})
+	var got legacyInfo
+	if err := Unmarshal(out, &got); err != nil || got.Name != "n" || got.PieceLength != 2 {
+		t.Errorf("Unmarshal() got = %+v, error = %v", got, err)
+	}
+	d := NewDecoder(bytes.NewReader(out))
+	d.RequireCanonical()
+	if err := d.Decode(&got); err == nil {
+		t.Error("Decode() of custom-ordered output in canonical mode returned no error")
+	}
+}
+
+// writeCounter records the number of calls to Write and the bytes written.
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderLargeMap(t *testing.T) {
+	// Keys are inserted in an order that is far from sorted, including keys
+	// that sort differently as strings and as numbers.
+	m := make(map[string]any, 100000)
+	for i := range 100000 {
+		key := strconv.Itoa(i * 7919 % 100000)
+		if i%3 == 0 {
+			m[key] = []any{int64(i), key}
+		} else {
+			m[key] = int64(i)
+		}
+	}
+
+	var out writeCounter
+	if err := NewEncoder(&out).Encode(m); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	want, err := Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("Encode() output differs from Marshal()")
+	}
+	if out.writes < 2 {
+		t.Errorf("Encode() made %d writes, want the output streamed", out.writes)
+	}
+
+	d := NewDecoder(bytes.NewReader(out.Bytes()))
+	d.RequireCanonical()
+	var got map[string]any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() in canonical mode error = %v", err)
+	}
+	if len(got) != len(m) {
+		t.Errorf("Decode() got %d keys, want %d", len(got), len(m))
+	}
+}
+
+func TestEncoderNestedMapsSorted(t *testing.T) {
+	v := []any{
+		map[string]any{"zeta": int64(1), "alpha": map[string]int{"b": 2, "a": 1}},
+		map[string]any{"10": "x", "9": "y", "": "z"},
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := "ld5:alphad1:ai1e1:bi2ee4:zetai1eed0:1:z2:101:x1:91:yee"
+	if buf.String() != want {
+		t.Errorf("Encode() got = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestArrayRoundTrip(t *testing.T) {
+	type peer struct {
+		InfoHash [20]byte  `bencode:"info_hash"`
+		Version  [3]int    `bencode:"version"`
+		Ports    [2]uint16 `bencode:"ports"`
+		Hashes   [][4]byte `bencode:"hashes"`
+	}
+	want := peer{
+		InfoHash: sha1.Sum([]byte("info")),
+		Version:  [3]int{1, 2, 3},
+		Ports:    [2]uint16{6881, 6882},
+		Hashes:   [][4]byte{{1, 2, 3, 4}, {5, 6, 7, 8}},
+	}
+
+	data, err := Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var got peer
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", data, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %+v, want %+v", got, want)
+	}
+
+	// The length of the input must match the array exactly.
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{in: "d7:versionli1ei2eee", want: `bencode: cannot unmarshal list of length 2 into Go value of type [3]int at key "version"`},
+		{in: "d7:versionli1ei2ei3ei4eee", want: `bencode: cannot unmarshal list of length 4 into Go value of type [3]int at key "version"`},
+		{in: "d9:info_hash3:abce", want: `bencode: cannot unmarshal string of length 3 into Go value of type [20]uint8 at key "info_hash"`},
+		{in: "d9:info_hashi1ee", want: `bencode: cannot unmarshal integer into Go value of type [20]uint8 at key "info_hash"`},
+	} {
+		var got peer
+		err := Unmarshal([]byte(tc.in), &got)
+		var typeErr *UnmarshalTypeError
+		if !errors.As(err, &typeErr) || err.Error() != tc.want {
+			t.Errorf("Unmarshal(%q) error = %v, want %s", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestMarshalDeterministic(t *testing.T) {
+	// Map iteration order is randomized, so repeated runs would differ if
+	// any map were encoded in iteration order.
+	v := map[string]any{
+		"announce": "http://tracker.example.com/announce",
+		"info": map[string]any{
+			"name":         "example",
+			"piece length": int64(16384),
+			"files": []any{
+				map[string]any{"length": int64(1), "path": []any{"a"}},
+				map[string]any{"path": []any{"b"}, "length": int64(2)},
+			},
+		},
+		"tags":    map[string]int{"z": 26, "a": 1, "m": 13, "b": 2, "y": 25},
+		"numbers": map[int]string{10: "ten", 9: "nine", -1: "minus one", 100: "hundred"},
+		"":        "empty key",
+	}
+
+	first, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	for i := range 100 {
+		got, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if !bytes.Equal(got, first) {
+			t.Fatalf("Marshal() run %d got = %q, want %q", i, got, first)
+		}
+	}
+}
+
+// celsius is a Stringer of a kind Bencode has no encoding for.
+type celsius float64
+
+func (c celsius) String() string { return strconv.FormatFloat(float64(c), 'f', 1, 64) + "C" }
+
+// seeders is a Stringer that is also an integer.
+type seeders int
+
+func (s seeders) String() string { return strconv.Itoa(int(s)) + " seeders" }
+
+// trackerError implements error through a pointer.
+type trackerError struct {
+	Code int
+}
+
+func (e *trackerError) Error() string { return "tracker error " + strconv.Itoa(e.Code) }
+
+func TestEncoderUseStringer(t *testing.T) {
+	type status struct {
+		Temp    celsius `bencode:"temp"`
+		Seeders seeders `bencode:"seeders"`
+		Err     error   `bencode:"err"`
+		Tracker *trackerError
+	}
+	v := status{
+		Temp:    21.5,
+		Seeders: 3,
+		Err:     errors.New("connection refused"),
+		Tracker: &trackerError{Code: 900},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseStringer()
+	if err := enc.Encode(v); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := "d7:Tracker17:tracker error 9003:err18:connection refused7:seedersi3e4:temp5:21.5Ce"
+	if buf.String() != want {
+		t.Errorf("Encode() got = %q, want %q", buf.String(), want)
+	}
+
+	// Without the option, the float is an error, as it is for Marshal.
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode(v); err == nil {
+		t.Errorf("Encode() without UseStringer wrote %q, want an error", buf.String())
+	}
+	if _, err := Marshal(celsius(1)); err == nil {
+		t.Error("Marshal() of a Stringer float returned no error")
+	}
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	info := TorrentInfo{
+		Name:        "test.txt",
+		PieceLength: 16384,
+		Pieces:      []byte("abcdefghijklmnopqrst"),
+		Length:      ptr(int64(1024)),
+	}
+	got, err := MarshalCanonical(info)
+	if err != nil {
+		t.Fatalf("MarshalCanonical() error = %v", err)
+	}
+	want := "d6:lengthi1024e4:name8:test.txt12:piece lengthi16384e6:pieces20:abcdefghijklmnopqrste"
+	if string(got) != want {
+		t.Errorf("MarshalCanonical() got = %q, want %q", got, want)
+	}
+	// The reference hash, from sha1sum of the encoding above.
+	const wantHash = "d5b0c44236abab33e939e2d592c7faedd931662b"
+	if sum := sha1.Sum(got); hex.EncodeToString(sum[:]) != wantHash {
+		t.Errorf("info hash = %x, want %s", sum, wantHash)
+	}
+
+	// Re-encoding a decoded info dictionary can change its hash, even when it
+	// was canonical: omitempty drops a private key set to zero.
+	const original = "d6:lengthi3e4:name1:n12:piece lengthi2e7:privatei0e6:source0:e"
+	var decoded legacyInfo
+	if err := Unmarshal([]byte(original), &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	got, err = MarshalCanonical(decoded)
+	if err != nil {
+		t.Fatalf("MarshalCanonical() error = %v", err)
+	}
+	if sha1.Sum(got) == sha1.Sum([]byte(original)) {
+		t.Errorf("MarshalCanonical() got = %q, want it to differ from %q", got, original)
+	}
+
+	// A custom key order is ignored.
+	got, err = MarshalCanonical(legacyInfo{Name: "n", PieceLength: 2, Length: 3})
+	if err != nil {
+		t.Fatalf("MarshalCanonical() error = %v", err)
+	}
+	if want := "d6:lengthi3e4:name1:n12:piece lengthi2e6:source0:e"; string(got) != want {
+		t.Errorf("MarshalCanonical() got = %q, want %q", got, want)
+	}
+}
+
+func TestMarshalCanonicalRawMessage(t *testing.T) {
+	testCases := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{name: "Canonical", raw: "d1:ai1e1:bli-2eee"},
+		{name: "Leading Zero", raw: "i007e", wantErr: true},
+		{name: "Unsorted Keys", raw: "d1:bi1e1:ai2ee", wantErr: true},
+		{name: "Length Leading Zero", raw: "03:abc", wantErr: true},
+		{name: "Trailing Bytes", raw: "i1ei2e", wantErr: true},
+		{name: "Truncated", raw: "li1e", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := map[string]RawMessage{"raw": RawMessage(tc.raw)}
+			got, err := MarshalCanonical(v)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("MarshalCanonical() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && string(got) != "d3:raw"+tc.raw+"e" {
+				t.Errorf("MarshalCanonical() got = %q", got)
+			}
+			// Marshal writes the RawMessage verbatim either way.
+			if _, err := Marshal(v); err != nil {
+				t.Errorf("Marshal() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package bencode
+
+import "math/big"
+
+// Equal reports whether a and b encode the same value. Unlike comparing the
+// bytes, it ignores the order of dictionary keys, so a dictionary equals any
+// reordering of itself, while lists must hold equal elements in the same
+// order. Integers compare by value, however many digits they are written
+// with. An error is returned if either input cannot be decoded.
+func Equal(a, b []byte) (bool, error) {
+	var va, vb any
+	if err := Unmarshal(a, &va); err != nil {
+		return false, err
+	}
+	if err := Unmarshal(b, &vb); err != nil {
+		return false, err
+	}
+	return equalValues(va, vb), nil
+}
+
+// equalValues reports whether a and b, values in the generic form returned by
+// decoding into an any, are equal.
+func equalValues(a, b any) bool {
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case int64:
+		b, ok := b.(int64)
+		return ok && a == b
+	case *big.Int:
+		// Decoded integers are only big when they do not fit in an int64,
+		// so a big integer never equals an int64.
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalValues(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, av := range a {
+			bv, ok := b[key]
+			if !ok || !equalValues(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
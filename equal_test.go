@@ -0,0 +1,50 @@
+package bencode
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	testCases := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "Identical", a: "d3:fooi1ee", b: "d3:fooi1ee", want: true},
+		{name: "Reordered Keys", a: "d1:ai1e1:b1:xe", b: "d1:b1:x1:ai1ee", want: true},
+		{name: "Nested Reordered", a: "ld1:ai1e1:bleee", b: "ld1:ble1:ai1eee", want: true},
+		{name: "Leading Zeros", a: "i007e", b: "i7e", want: true},
+		{name: "Big Integers", a: "i123456789012345678901234567890e", b: "i0123456789012345678901234567890e", want: true},
+		{name: "Empty Containers", a: "le", b: "le", want: true},
+		{name: "Different Strings", a: "4:spam", b: "4:eggs"},
+		{name: "Different Integers", a: "i1e", b: "i2e"},
+		{name: "Big And Small", a: "i123456789012345678901234567890e", b: "i1e"},
+		{name: "List Order", a: "li1ei2ee", b: "li2ei1ee"},
+		{name: "List Length", a: "li1ee", b: "li1ei1ee"},
+		{name: "Missing Key", a: "d1:ai1e1:bi2ee", b: "d1:ai1e1:ci2ee"},
+		{name: "Extra Key", a: "d1:ai1ee", b: "d1:ai1e1:bi2ee"},
+		{name: "Different Value", a: "d1:ai1ee", b: "d1:ai2ee"},
+		{name: "String And Integer", a: "1:1", b: "i1e"},
+		{name: "Empty List And Dictionary", a: "le", b: "de"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, args := range [][2]string{{tc.a, tc.b}, {tc.b, tc.a}} {
+				got, err := Equal([]byte(args[0]), []byte(args[1]))
+				if err != nil {
+					t.Fatalf("Equal(%q, %q) error = %v", args[0], args[1], err)
+				}
+				if got != tc.want {
+					t.Errorf("Equal(%q, %q) = %v, want %v", args[0], args[1], got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEqualError(t *testing.T) {
+	for _, args := range [][2]string{{"i1", "i1e"}, {"i1e", "l"}, {"", ""}} {
+		if _, err := Equal([]byte(args[0]), []byte(args[1])); err == nil {
+			t.Errorf("Equal(%q, %q) returned no error", args[0], args[1])
+		}
+	}
+}
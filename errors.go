@@ -1,6 +1,19 @@
 package bencode
 
-import "reflect"
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrUnterminatedList and ErrUnterminatedDict are wrapped by the errors for
+// input that ends inside a list or dictionary, before its closing 'e'. The
+// errors also wrap io.ErrUnexpectedEOF.
+var (
+	ErrUnterminatedList = errors.New("bencode: unterminated list")
+	ErrUnterminatedDict = errors.New("bencode: unterminated dictionary")
+)
 
 // InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
 // (The argument to Unmarshal must be a non-nil pointer.)
@@ -17,3 +30,65 @@ func (e *InvalidUnmarshalError) Error() string {
 	}
 	return "bencode: Unmarshal(nil " + e.Type.String() + ")"
 }
+
+// An UnmarshalTypeError describes a Bencode value that was not appropriate for
+// a value of a specific Go type.
+//
+// Offset is known when unmarshaling a byte slice. A Decoder reading from a
+// stream only keeps the input it needs to find it for values decoded into a
+// type that can hold a RawMessage, and reports -1 otherwise.
+type UnmarshalTypeError struct {
+	Value  string       // description of the Bencode value: "string", "integer 300", "list", "dictionary"
+	Type   reflect.Type // type of Go value it could not be assigned to
+	Field  string       // the full path of dictionary keys to the value, separated by dots, if any
+	Offset int64        // the input offset at which the value starts, or -1 if it is not known
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return "bencode: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String() + " at key " + strconv.Quote(e.Field)
+	}
+	return "bencode: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+}
+
+// An UnsupportedValueError is returned by Marshal when attempting to encode an
+// unsupported value, such as one that contains itself.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "bencode: unsupported value: " + e.Str
+}
+
+// A KeyTypeError describes a dictionary key that is a Bencode value other than
+// a string, which usually points to a broken encoder upstream.
+type KeyTypeError struct {
+	Value  string // the kind of value found: "integer", "list" or "dictionary"
+	Offset int64  // the input offset at which the key starts
+}
+
+func (e *KeyTypeError) Error() string {
+	return "bencode: dictionary key at offset " + strconv.FormatInt(e.Offset, 10) + " must be a string, found " + e.Value
+}
+
+// A RecoveredError is returned by a Decoder in lenient mode, set with Lenient,
+// when it skipped malformed values. The decoded value is complete apart from
+// them.
+type RecoveredError struct {
+	Errors []error // the errors for the skipped values, in input order
+}
+
+func (e *RecoveredError) Error() string {
+	first := strings.TrimPrefix(e.Errors[0].Error(), "bencode: ")
+	if len(e.Errors) == 1 {
+		return "bencode: skipped a malformed value: " + first
+	}
+	return "bencode: skipped " + strconv.Itoa(len(e.Errors)) + " malformed values, the first: " + first
+}
+
+// Unwrap returns the errors for the skipped values.
+func (e *RecoveredError) Unwrap() []error {
+	return e.Errors
+}
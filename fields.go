@@ -0,0 +1,125 @@
+package bencode
+
+import "reflect"
+
+// field describes a struct field that maps to a dictionary key.
+type field struct {
+	key   string
+	opts  tagOptions
+	index []int // The field's index sequence, for use with FieldByIndex.
+}
+
+// structFields returns the fields of the struct type t that map to dictionary
+// keys, in declaration order. Keys and options are read from the struct tag
+// with the given name.
+//
+// The exported fields of an embedded struct without a tag name are promoted
+// into the parent's keys, as encoding/json does. When several fields share a
+// key, the least nested one wins, and if there is more than one at that depth
+// the key is ambiguous and all of them are ignored.
+func structFields(t reflect.Type, tagName string) []field {
+	var all []field
+	collectFields(t, tagName, nil, map[reflect.Type]bool{t: true}, &all)
+
+	type dominant struct {
+		depth int
+		count int
+	}
+	keys := make(map[string]dominant, len(all))
+	for _, f := range all {
+		depth := len(f.index)
+		if k, ok := keys[f.key]; !ok || depth < k.depth {
+			keys[f.key] = dominant{depth: depth, count: 1}
+		} else if depth == k.depth {
+			k.count++
+			keys[f.key] = k
+		}
+	}
+
+	fields := make([]field, 0, len(all))
+	for _, f := range all {
+		if k := keys[f.key]; k.count == 1 && k.depth == len(f.index) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// collectFields appends the fields of the struct type t to fields, recursing
+// into embedded structs. index is the index sequence of t within the
+// outermost struct, and visited holds the embedded types along the current
+// path, to stop at recursive embeddings.
+func collectFields(t reflect.Type, tagName string, index []int, visited map[reflect.Type]bool, fields *[]field) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, opts, ok := fieldKey(sf, tagName)
+		if !ok {
+			continue
+		}
+		fieldIndex := append(index[:len(index):len(index)], i)
+
+		if name, _ := parseTag(sf.Tag.Get(tagName)); sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				// A pointer to an unexported struct type cannot be allocated
+				// when decoding, so its fields are ignored.
+				if !sf.IsExported() && sf.Type.Kind() == reflect.Pointer {
+					continue
+				}
+				if !visited[ft] {
+					visited[ft] = true
+					collectFields(ft, tagName, fieldIndex, visited, fields)
+					delete(visited, ft)
+				}
+				continue
+			}
+		}
+
+		// Skip unexported fields.
+		if !sf.IsExported() {
+			continue
+		}
+		*fields = append(*fields, field{key: key, opts: opts, index: fieldIndex})
+	}
+}
+
+// fieldByIndexAlloc returns the field of the struct v with the given index
+// sequence, allocating any nil embedded struct pointers along the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// quotable reports whether a field of type t, or of a pointer to it, is
+// affected by the "string" tag option, which writes integers and booleans as
+// strings of their text. Types with a text form of their own are left alone.
+func quotable(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == durationType {
+		return true
+	}
+	pt := reflect.PointerTo(t)
+	if pt.Implements(textMarshalerType) || pt.Implements(textUnmarshalerType) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return true
+	case reflect.Uintptr:
+		return false
+	}
+	return isIntKind(t.Kind())
+}
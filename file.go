@@ -0,0 +1,62 @@
+package bencode
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+)
+
+// fileBufferSize is the buffer size used for reading and writing files, which
+// is larger than the bufio default to suit torrents with large piece lists.
+const fileBufferSize = 64 << 10
+
+// UnmarshalFile decodes the first Bencode value in the named file into v.
+// If v is nil or not a pointer, UnmarshalFile returns an InvalidUnmarshalError.
+func UnmarshalFile(name string, v any) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return NewDecoder(bufio.NewReaderSize(f, fileBufferSize)).Decode(v)
+}
+
+// MarshalFile writes the Bencode encoding of v to the named file, replacing
+// it if it already exists. The encoding is written to a temporary file in the
+// same directory, which is renamed over the named file only once it is
+// complete, so a failed encoding leaves an existing file unchanged.
+//
+// An existing file keeps its permissions, and a new one is created with
+// permissions 0644.
+func MarshalFile(name string, v any) (err error) {
+	perm := os.FileMode(0o644)
+	if fi, err := os.Stat(name); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := NewEncoder(bufio.NewWriterSize(f, fileBufferSize)).Encode(v); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,108 @@
+package bencode
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	type info struct {
+		Name        string `bencode:"name"`
+		PieceLength int64  `bencode:"piece length"`
+		Pieces      []byte `bencode:"pieces"`
+	}
+	type torrent struct {
+		Announce string `bencode:"announce"`
+		Info     info   `bencode:"info"`
+	}
+
+	// Pieces larger than the file buffer exercise buffered reads and writes.
+	want := torrent{
+		Announce: "http://tracker.example.com/announce",
+		Info: info{
+			Name:        "example.iso",
+			PieceLength: 262144,
+			Pieces:      []byte(strings.Repeat("0123456789abcdefghij", 10000)),
+		},
+	}
+
+	name := filepath.Join(t.TempDir(), "example.torrent")
+	if err := MarshalFile(name, want); err != nil {
+		t.Fatalf("MarshalFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if encoded, _ := Marshal(want); string(data) != string(encoded) {
+		t.Errorf("MarshalFile() wrote %d bytes, want the %d bytes of Marshal", len(data), len(encoded))
+	}
+
+	var got torrent
+	if err := UnmarshalFile(name, &got); err != nil {
+		t.Fatalf("UnmarshalFile() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalFile() got a different value than was written")
+	}
+}
+
+func TestFileError(t *testing.T) {
+	dir := t.TempDir()
+
+	var v any
+	if err := UnmarshalFile(filepath.Join(dir, "missing.torrent"), &v); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("UnmarshalFile() error = %v, want fs.ErrNotExist", err)
+	}
+	if err := MarshalFile(filepath.Join(dir, "missing", "out.torrent"), 1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("MarshalFile() error = %v, want fs.ErrNotExist", err)
+	}
+	if err := MarshalFile(filepath.Join(dir, "bad.torrent"), make(chan int)); err == nil {
+		t.Error("MarshalFile() of an unsupported type returned no error")
+	}
+}
+
+func TestMarshalFileReplace(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "example.torrent")
+	if err := os.WriteFile(name, []byte("4:keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed encoding leaves the existing file as it was, and no temporary
+	// file behind.
+	if err := MarshalFile(name, []any{"partial", make(chan int)}); err == nil {
+		t.Fatal("MarshalFile() of an unsupported type returned no error")
+	}
+	if data, err := os.ReadFile(name); err != nil || string(data) != "4:keep" {
+		t.Errorf("file after a failed MarshalFile() = %q, %v, want %q", data, err, "4:keep")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries after a failed MarshalFile(), want 1", len(entries))
+	}
+
+	// A successful one replaces it, keeping its permissions.
+	if err := MarshalFile(name, "new"); err != nil {
+		t.Fatalf("MarshalFile() error = %v", err)
+	}
+	if data, err := os.ReadFile(name); err != nil || string(data) != "3:new" {
+		t.Errorf("file after MarshalFile() = %q, %v, want %q", data, err, "3:new")
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0o600 {
+		t.Errorf("file after MarshalFile() has mode %v, want %v", fi.Mode().Perm(), fs.FileMode(0o600))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries after MarshalFile(), want 1", len(entries))
+	}
+}
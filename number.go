@@ -0,0 +1,43 @@
+package bencode
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// A Number is a Bencode integer held as its decimal digits, so that the choice
+// of Go integer type can be made after decoding without losing precision.
+//
+// A Decoder produces Numbers in place of int64 and *big.Int values when
+// decoding into an any after UseNumber has been called. A Number is also
+// encoded as an integer.
+type Number string
+
+// String returns the decimal digits of n.
+func (n Number) String() string {
+	return string(n)
+}
+
+// Int64 returns n as an int64, or an error if it does not fit.
+func (n Number) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+// BigInt returns n as a *big.Int, which can hold an integer of any size.
+func (n Number) BigInt() (*big.Int, error) {
+	b, ok := new(big.Int).SetString(string(n), 10)
+	if !ok {
+		return nil, fmt.Errorf("bencode: invalid Number %q", string(n))
+	}
+	return b, nil
+}
+
+// value returns n in the form used for integers when not decoding into a
+// Number: an int64, or a *big.Int if it does not fit in an int64.
+func (n Number) value() (any, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	return n.BigInt()
+}
@@ -0,0 +1,131 @@
+package bencode
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoderUseNumber(t *testing.T) {
+	const huge = "123456789012345678901234567890"
+
+	d := NewDecoder(strings.NewReader("d5:smalli-42e4:hugei" + huge + "e4:listli7eee"))
+	d.UseNumber()
+
+	var got any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := map[string]any{
+		"small": Number("-42"),
+		"huge":  Number(huge),
+		"list":  []any{Number("7")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Decode() got = %#v, want %#v", got, want)
+	}
+
+	small := got.(map[string]any)["small"].(Number)
+	if i, err := small.Int64(); err != nil || i != -42 {
+		t.Errorf("Int64() = %d, %v, want -42, nil", i, err)
+	}
+	if b, err := small.BigInt(); err != nil || b.Int64() != -42 {
+		t.Errorf("BigInt() = %v, %v, want -42, nil", b, err)
+	}
+
+	large := got.(map[string]any)["huge"].(Number)
+	if _, err := large.Int64(); err == nil {
+		t.Error("Int64() of an overflowing Number returned no error")
+	}
+	wantBig, _ := new(big.Int).SetString(huge, 10)
+	if b, err := large.BigInt(); err != nil || b.Cmp(wantBig) != 0 {
+		t.Errorf("BigInt() = %v, %v, want %s, nil", b, err, huge)
+	}
+}
+
+func TestDecoderUseNumberResultType(t *testing.T) {
+	// The same input decodes to an int64 without UseNumber and to a Number
+	// with it, whether read with Decode or Token.
+	const in = "i1700000000e"
+
+	var plain any
+	if err := NewDecoder(strings.NewReader(in)).Decode(&plain); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if _, ok := plain.(int64); !ok {
+		t.Fatalf("Decode() without UseNumber got %T, want int64", plain)
+	}
+
+	d := NewDecoder(strings.NewReader(in + in))
+	d.UseNumber()
+	var got any
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	n, ok := got.(Number)
+	if !ok {
+		t.Fatalf("Decode() with UseNumber got %T, want Number", got)
+	}
+	if i, err := n.Int64(); err != nil || i != plain.(int64) {
+		t.Errorf("Int64() = %d, %v, want %d, nil", i, err, plain)
+	}
+
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok != Number("1700000000") {
+		t.Errorf("Token() with UseNumber got %#v, want Number(\"1700000000\")", tok)
+	}
+}
+
+func TestDecoderUseNumberTyped(t *testing.T) {
+	var got struct {
+		Size   int64   `bencode:"size"`
+		Total  big.Int `bencode:"total"`
+		Exact  Number  `bencode:"exact"`
+		Values []any   `bencode:"values"`
+	}
+
+	d := NewDecoder(strings.NewReader("d5:exacti99999999999999999999e4:sizei5e5:totali18446744073709551616e6:valuesli1eee"))
+	d.UseNumber()
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got.Size != 5 {
+		t.Errorf("Size = %d, want 5", got.Size)
+	}
+	if got.Total.String() != "18446744073709551616" {
+		t.Errorf("Total = %s, want 18446744073709551616", got.Total.String())
+	}
+	if got.Exact != "99999999999999999999" {
+		t.Errorf("Exact = %q, want 99999999999999999999", got.Exact)
+	}
+	if !reflect.DeepEqual(got.Values, []any{Number("1")}) {
+		t.Errorf("Values = %#v, want [Number(1)]", got.Values)
+	}
+
+	// A Number field is filled without UseNumber too.
+	var n Number
+	if err := Unmarshal([]byte("i-3e"), &n); err != nil || n != "-3" {
+		t.Errorf("Unmarshal() into Number = %q, %v, want -3, nil", n, err)
+	}
+	if err := Unmarshal([]byte("1:x"), &n); err == nil {
+		t.Error("Unmarshal() of a string into Number returned no error")
+	}
+}
+
+func TestMarshalNumber(t *testing.T) {
+	got, err := Marshal([]any{Number("-7"), Number("123456789012345678901234567890")})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if want := "li-7ei123456789012345678901234567890ee"; string(got) != want {
+		t.Errorf("Marshal() got = %q, want %q", got, want)
+	}
+
+	if _, err := Marshal(Number("12x")); err == nil {
+		t.Error("Marshal() of an invalid Number returned no error")
+	}
+}
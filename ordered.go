@@ -0,0 +1,91 @@
+package bencode
+
+import (
+	"slices"
+	"sort"
+)
+
+// OrderedDict is a Bencode dictionary that keeps its keys in insertion order.
+// The zero value is an empty dictionary ready to use.
+//
+// Marshal always writes dictionary keys in sorted order. An OrderedDict tracks
+// whether its keys are already sorted, so that encoding one that is, such as
+// one decoded from canonical input, does not need to sort them again.
+type OrderedDict struct {
+	keys   []string
+	values map[string]any
+
+	// unsorted is set once a key is added out of order. It is inverted so
+	// that the zero value, which has no keys, is known to be sorted.
+	unsorted bool
+}
+
+// Len returns the number of keys in d.
+func (d *OrderedDict) Len() int {
+	return len(d.keys)
+}
+
+// Keys returns the keys of d in order.
+func (d *OrderedDict) Keys() []string {
+	return slices.Clone(d.keys)
+}
+
+// Get returns the value for key and whether it was present.
+func (d *OrderedDict) Get(key string) (any, bool) {
+	value, ok := d.values[key]
+	return value, ok
+}
+
+// Set sets the value for key. A new key is added at the end of d, while an
+// existing key keeps its position.
+func (d *OrderedDict) Set(key string, value any) {
+	if d.values == nil {
+		d.values = make(map[string]any)
+	}
+	if _, ok := d.values[key]; !ok {
+		if len(d.keys) > 0 && key < d.keys[len(d.keys)-1] {
+			d.unsorted = true
+		}
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = value
+}
+
+// Delete removes key from d, if present.
+func (d *OrderedDict) Delete(key string) {
+	if _, ok := d.values[key]; !ok {
+		return
+	}
+	delete(d.values, key)
+	d.keys = slices.DeleteFunc(d.keys, func(k string) bool { return k == key })
+}
+
+// Sorted reports whether the keys of d are in sorted order.
+func (d *OrderedDict) Sorted() bool {
+	return !d.unsorted
+}
+
+// sortedKeys returns the keys of d in sorted order, copying and sorting them
+// only if they are not sorted already.
+func (d *OrderedDict) sortedKeys() []string {
+	if !d.unsorted {
+		return d.keys
+	}
+	keys := slices.Clone(d.keys)
+	sort.Strings(keys)
+	return keys
+}
+
+// OrderedStruct is implemented by struct types whose dictionary keys must be
+// written in a particular order, rather than sorted, for compatibility with
+// legacy producers and consumers that depend on it.
+//
+// Marshal writes the keys returned by KeyOrder first, in that order, followed
+// by any other keys in sorted order. Keys that are not written, such as those
+// of empty fields with the "omitempty" option, are skipped. The output is not
+// in canonical form unless KeyOrder happens to return the keys sorted, so it
+// must not be used where encoded data is hashed, such as a torrent's info
+// dictionary.
+type OrderedStruct interface {
+	KeyOrder() []string
+}
@@ -0,0 +1,179 @@
+package bencode
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOrderedDict(t *testing.T) {
+	var d OrderedDict
+	d.Set("b", int64(1))
+	d.Set("a", "x")
+	d.Set("b", int64(2)) // Existing key keeps its position
+
+	if got, want := d.Keys(), []string{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+	if v, ok := d.Get("b"); !ok || v != int64(2) {
+		t.Errorf("Get(%q) = %v, %v, want 2, true", "b", v, ok)
+	}
+
+	d.Delete("b")
+	if d.Len() != 1 {
+		t.Errorf("Len() = %d after Delete, want 1", d.Len())
+	}
+	if _, ok := d.Get("b"); ok {
+		t.Errorf("Get(%q) found a deleted key", "b")
+	}
+}
+
+func TestOrderedDictSorted(t *testing.T) {
+	const canonical = "d1:ai1e1:bi2e1:ci3ee"
+
+	d := NewDecoder(strings.NewReader(canonical))
+	d.RequireCanonical()
+
+	var od OrderedDict
+	if err := d.Decode(&od); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !od.Sorted() {
+		t.Fatal("Sorted() = false after decoding canonical input")
+	}
+
+	// Appending a key that sorts last keeps the flag.
+	od.Set("d", int64(4))
+	if !od.Sorted() {
+		t.Error("Sorted() = false after appending a key in order")
+	}
+
+	// Tampering with the order clears it, and the encoder must then sort.
+	od.Set("0", int64(0))
+	if od.Sorted() {
+		t.Error("Sorted() = true after adding a key out of order")
+	}
+	got, err := Marshal(od)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if want := "d1:0i0e1:ai1e1:bi2e1:ci3e1:di4ee"; string(got) != want {
+		t.Errorf("Marshal() got = %q, want %q", got, want)
+	}
+	if got, want := od.Keys(), []string{"a", "b", "c", "d", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v after Marshal, want insertion order %v", got, want)
+	}
+}
+
+func BenchmarkEncodeOrderedDict(b *testing.B) {
+	newDict := func(sorted bool) *OrderedDict {
+		var d OrderedDict
+		for i := 0; i < 1000; i++ {
+			n := i
+			if !sorted {
+				n = 999 - i
+			}
+			d.Set("key"+strconv.Itoa(1000+n), int64(n))
+		}
+		return &d
+	}
+
+	for _, sorted := range []bool{true, false} {
+		d := newDict(sorted)
+		b.Run("Sorted="+strconv.FormatBool(sorted), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Marshal(d); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderUseOrderedDict(t *testing.T) {
+	const in = "d4:spami1e3:eggd1:zi1e1:ai2ee5:applei3ee"
+
+	t.Run("Map", func(t *testing.T) {
+		var got any
+		if err := NewDecoder(strings.NewReader(in)).Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		want := map[string]any{
+			"spam":  int64(1),
+			"egg":   map[string]any{"z": int64(1), "a": int64(2)},
+			"apple": int64(3),
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Decode() got = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("Ordered", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader(in))
+		d.UseOrderedDict()
+
+		var got any
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		od, ok := got.(*OrderedDict)
+		if !ok {
+			t.Fatalf("Decode() got %T, want *OrderedDict", got)
+		}
+		if keys, want := od.Keys(), []string{"spam", "egg", "apple"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("Keys() = %v, want %v", keys, want)
+		}
+		if od.Sorted() {
+			t.Error("Sorted() = true for keys out of order")
+		}
+
+		egg, _ := od.Get("egg")
+		inner, ok := egg.(*OrderedDict)
+		if !ok {
+			t.Fatalf("Get(%q) got %T, want *OrderedDict", "egg", egg)
+		}
+		if keys, want := inner.Keys(), []string{"z", "a"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("inner Keys() = %v, want %v", keys, want)
+		}
+
+		// Encoding still sorts the keys.
+		out, err := Marshal(got)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if want := "d5:applei3e3:eggd1:ai2e1:zi1ee4:spami1ee"; string(out) != want {
+			t.Errorf("Marshal() got = %q, want %q", out, want)
+		}
+	})
+
+	t.Run("Typed", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader(in))
+		d.UseOrderedDict()
+
+		var got struct {
+			Egg   map[string]int `bencode:"egg"`
+			Apple int            `bencode:"apple"`
+		}
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if got.Apple != 3 || !reflect.DeepEqual(got.Egg, map[string]int{"z": 1, "a": 2}) {
+			t.Errorf("Decode() got = %+v", got)
+		}
+	})
+
+	t.Run("Into OrderedDict", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader(in))
+		d.UseOrderedDict()
+
+		var got OrderedDict
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if keys, want := got.Keys(), []string{"spam", "egg", "apple"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("Keys() = %v, want %v", keys, want)
+		}
+	})
+}
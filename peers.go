@@ -0,0 +1,76 @@
+package bencode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// DecodeCompactPeers parses a tracker's compact peer list, as returned in the
+// "peers" key of an announce response (BEP 23). Each peer is 6 bytes: a 4-byte
+// IPv4 address followed by a 2-byte port, both in network byte order.
+func DecodeCompactPeers(s string) ([]netip.AddrPort, error) {
+	return decodeCompactPeers(s, 4)
+}
+
+// DecodeCompactPeers6 parses a tracker's compact IPv6 peer list, as returned
+// in the "peers6" key of an announce response (BEP 7). Each peer is 18 bytes:
+// a 16-byte IPv6 address followed by a 2-byte port, both in network byte
+// order.
+func DecodeCompactPeers6(s string) ([]netip.AddrPort, error) {
+	return decodeCompactPeers(s, 16)
+}
+
+// decodeCompactPeers parses a compact peer list whose addresses are addrLen
+// bytes long.
+func decodeCompactPeers(s string, addrLen int) ([]netip.AddrPort, error) {
+	size := addrLen + 2
+	if len(s)%size != 0 {
+		return nil, fmt.Errorf("bencode: compact peer list length %d is not a multiple of %d", len(s), size)
+	}
+
+	peers := make([]netip.AddrPort, 0, len(s)/size)
+	for i := 0; i < len(s); i += size {
+		var addr netip.Addr
+		if addrLen == 4 {
+			addr = netip.AddrFrom4([4]byte([]byte(s[i : i+4])))
+		} else {
+			addr = netip.AddrFrom16([16]byte([]byte(s[i : i+16])))
+		}
+		port := binary.BigEndian.Uint16([]byte(s[i+addrLen : i+size]))
+		peers = append(peers, netip.AddrPortFrom(addr, port))
+	}
+	return peers, nil
+}
+
+// EncodeCompactPeers returns the compact form of peers for the "peers" key of
+// an announce response, the inverse of DecodeCompactPeers. Every peer must
+// have an IPv4 address, or an IPv4-mapped IPv6 address.
+func EncodeCompactPeers(peers []netip.AddrPort) (string, error) {
+	buf := make([]byte, 0, len(peers)*6)
+	for _, p := range peers {
+		addr := p.Addr().Unmap()
+		if !addr.Is4() {
+			return "", fmt.Errorf("bencode: cannot encode %s as a compact IPv4 peer", p)
+		}
+		buf = append(buf, addr.AsSlice()...)
+		buf = binary.BigEndian.AppendUint16(buf, p.Port())
+	}
+	return string(buf), nil
+}
+
+// EncodeCompactPeers6 returns the compact form of peers for the "peers6" key
+// of an announce response, the inverse of DecodeCompactPeers6. Every peer
+// must have an IPv6 address; IPv4 peers belong in the "peers" list instead.
+func EncodeCompactPeers6(peers []netip.AddrPort) (string, error) {
+	buf := make([]byte, 0, len(peers)*18)
+	for _, p := range peers {
+		addr := p.Addr()
+		if !addr.Is6() || addr.Is4In6() {
+			return "", fmt.Errorf("bencode: cannot encode %s as a compact IPv6 peer", p)
+		}
+		buf = append(buf, addr.AsSlice()...)
+		buf = binary.BigEndian.AppendUint16(buf, p.Port())
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,139 @@
+package bencode
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCompactPeers(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    []netip.AddrPort
+		wantErr bool
+	}{
+		{name: "Empty", in: "", want: []netip.AddrPort{}},
+		{
+			name: "Two Peers",
+			in:   "\x7f\x00\x00\x01\x1a\xe1" + "\xc0\xa8\x01\x02\xff\xff",
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("127.0.0.1:6881"),
+				netip.MustParseAddrPort("192.168.1.2:65535"),
+			},
+		},
+		{name: "Truncated", in: "\x7f\x00\x00\x01\x1a", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeCompactPeers(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("DecodeCompactPeers() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("DecodeCompactPeers() got = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCompactPeers6(t *testing.T) {
+	in := "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x50"
+	want := []netip.AddrPort{
+		netip.MustParseAddrPort("[2001:db8::1]:6881"),
+		netip.MustParseAddrPort("[::1]:80"),
+	}
+
+	got, err := DecodeCompactPeers6(in)
+	if err != nil {
+		t.Fatalf("DecodeCompactPeers6() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeCompactPeers6() got = %v, want %v", got, want)
+	}
+
+	// A length that is not a multiple of 18 is rejected.
+	if _, err := DecodeCompactPeers6(in[:12]); err == nil {
+		t.Error("DecodeCompactPeers6() of a truncated list returned no error")
+	}
+}
+
+func TestDecodeCompactPeersResponse(t *testing.T) {
+	var resp struct {
+		Interval int    `bencode:"interval"`
+		Peers    string `bencode:"peers"`
+	}
+	if err := Unmarshal([]byte("d8:intervali1800e5:peers6:\x0a\x00\x00\x01\x1f\x90e"), &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	peers, err := DecodeCompactPeers(resp.Peers)
+	if err != nil {
+		t.Fatalf("DecodeCompactPeers() error = %v", err)
+	}
+	if want := []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:8080")}; !reflect.DeepEqual(peers, want) {
+		t.Errorf("DecodeCompactPeers() got = %v, want %v", peers, want)
+	}
+}
+
+func TestEncodeCompactPeers(t *testing.T) {
+	peers := []netip.AddrPort{
+		netip.MustParseAddrPort("127.0.0.1:6881"),
+		netip.MustParseAddrPort("[::ffff:192.168.1.2]:65535"),
+	}
+	got, err := EncodeCompactPeers(peers)
+	if err != nil {
+		t.Fatalf("EncodeCompactPeers() error = %v", err)
+	}
+	if want := "\x7f\x00\x00\x01\x1a\xe1" + "\xc0\xa8\x01\x02\xff\xff"; got != want {
+		t.Errorf("EncodeCompactPeers() got = %q, want %q", got, want)
+	}
+
+	decoded, err := DecodeCompactPeers(got)
+	if err != nil {
+		t.Fatalf("DecodeCompactPeers() error = %v", err)
+	}
+	want := []netip.AddrPort{peers[0], netip.MustParseAddrPort("192.168.1.2:65535")}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("round trip got = %v, want %v", decoded, want)
+	}
+
+	for _, bad := range []netip.AddrPort{netip.MustParseAddrPort("[2001:db8::1]:80"), {}} {
+		if _, err := EncodeCompactPeers([]netip.AddrPort{bad}); err == nil {
+			t.Errorf("EncodeCompactPeers(%v) returned no error", bad)
+		}
+	}
+}
+
+func TestEncodeCompactPeers6(t *testing.T) {
+	peers := []netip.AddrPort{
+		netip.MustParseAddrPort("[2001:db8::1]:6881"),
+		netip.MustParseAddrPort("[::1]:80"),
+	}
+	got, err := EncodeCompactPeers6(peers)
+	if err != nil {
+		t.Fatalf("EncodeCompactPeers6() error = %v", err)
+	}
+	if len(got) != 36 {
+		t.Fatalf("EncodeCompactPeers6() got %d bytes, want 36", len(got))
+	}
+
+	decoded, err := DecodeCompactPeers6(got)
+	if err != nil {
+		t.Fatalf("DecodeCompactPeers6() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded, peers) {
+		t.Errorf("round trip got = %v, want %v", decoded, peers)
+	}
+
+	for _, bad := range []netip.AddrPort{
+		netip.MustParseAddrPort("127.0.0.1:80"),
+		netip.MustParseAddrPort("[::ffff:127.0.0.1]:80"),
+		{},
+	} {
+		if _, err := EncodeCompactPeers6([]netip.AddrPort{bad}); err == nil {
+			t.Errorf("EncodeCompactPeers6(%v) returned no error", bad)
+		}
+	}
+}
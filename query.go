@@ -0,0 +1,52 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned, wrapped, by Query when its path does not lead to a
+// value.
+var ErrNotFound = errors.New("bencode: path not found")
+
+// Query returns the value found by following path from the top-level value in
+// data, such as the name of a torrent with Query(data, "info", "name"). Each
+// element of the path is either a dictionary key or, where the value reached
+// so far is a list, the decimal index of an element. The value is returned in
+// the same form as when decoding into an any.
+//
+// If the path leads nowhere, because a key is missing, an index is out of
+// range or a string or integer is reached before the end, the error wraps
+// ErrNotFound. An empty path returns the whole value.
+//
+// Query currently decodes all of data before following the path, so it saves
+// the caller declaring types rather than decoding work.
+func Query(data []byte, path ...string) (any, error) {
+	var v any
+	if err := Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	for i, elem := range path {
+		at := "/" + strings.Join(path[:i], "/")
+		switch container := v.(type) {
+		case map[string]any:
+			next, ok := container[elem]
+			if !ok {
+				return nil, fmt.Errorf("%w: no key %q in the dictionary at %s", ErrNotFound, elem, at)
+			}
+			v = next
+		case []any:
+			index, err := strconv.Atoi(elem)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, fmt.Errorf("%w: no index %q in the list of %d elements at %s", ErrNotFound, elem, len(container), at)
+			}
+			v = container[index]
+		default:
+			return nil, fmt.Errorf("%w: cannot look up %q in the %s at %s", ErrNotFound, elem, describe(v), at)
+		}
+	}
+	return v, nil
+}
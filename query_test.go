@@ -0,0 +1,76 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	testCases := []struct {
+		name string
+		path []string
+		want any
+	}{
+		{name: "Top Level Key", path: []string{"announce"}, want: "http://tracker.example.com/announce"},
+		{name: "Nested Key", path: []string{"info", "name"}, want: "example"},
+		{name: "Key With Space", path: []string{"info", "piece length"}, want: int64(16384)},
+		{name: "List Index", path: []string{"announce-list", "1", "0"}, want: "http://tier2.example.net/annce"},
+		{name: "Through Lists And Dictionaries", path: []string{"info", "files", "0", "path", "1"}, want: "a.txt"},
+		{name: "Whole List", path: []string{"url-list"}, want: []any{"http://web.example.com"}},
+		{name: "Empty Path", path: nil, want: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Query([]byte(multiTrackerTorrent), tc.path...)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if tc.path == nil {
+				if _, ok := got.(map[string]any); !ok {
+					t.Errorf("Query() got = %T, want the whole dictionary", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Query() got = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQueryNotFound(t *testing.T) {
+	testCases := []struct {
+		name    string
+		path    []string
+		wantErr string
+	}{
+		{name: "Missing Key", path: []string{"info", "md5sum"}, wantErr: `no key "md5sum" in the dictionary at /info`},
+		{name: "Index Out Of Range", path: []string{"announce-list", "2"}, wantErr: `no index "2" in the list of 2 elements at /announce-list`},
+		{name: "Negative Index", path: []string{"announce-list", "-1"}},
+		{name: "Key Into List", path: []string{"announce-list", "name"}},
+		{name: "Into String", path: []string{"info", "name", "0"}, wantErr: `cannot look up "0" in the string at /info/name`},
+		{name: "Into Integer", path: []string{"creation date", "year"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Query([]byte(multiTrackerTorrent), tc.path...)
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Query() = %#v, %v, want an ErrNotFound error", got, err)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Query() error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	_, err := Query([]byte("d4:infod4:name"), "info", "name")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Query() error = %v, want a decoding error", err)
+	}
+}
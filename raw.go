@@ -0,0 +1,224 @@
+package bencode
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// rawTypes caches whether values of a type can hold a RawMessage, as reported
+// by holdsRawMessage.
+var rawTypes sync.Map // map[reflect.Type]rawInfo
+
+// rawInfo describes where a RawMessage can be found in a type: in the type
+// itself, or possibly behind an interface, which a registered type could fill
+// with one.
+type rawInfo struct {
+	raw   bool
+	iface bool
+}
+
+// holdsRawMessage reports whether decoding into a value of type t can store a
+// RawMessage, in which case the encoded form of the value must be kept while
+// decoding it. Interfaces count only when the Decoder has registered types.
+func (d *Decoder) holdsRawMessage(t reflect.Type) bool {
+	info := rawInfoOf(t)
+	return info.raw || info.iface && len(d.types) > 0
+}
+
+// rawInfoOf returns the rawInfo for t, from the cache where possible.
+func rawInfoOf(t reflect.Type) rawInfo {
+	if info, ok := rawTypes.Load(t); ok {
+		return info.(rawInfo)
+	}
+	var info rawInfo
+	collectRawInfo(t, map[reflect.Type]bool{}, &info)
+	rawTypes.Store(t, info)
+	return info
+}
+
+// collectRawInfo adds what t and the types it contains hold to info. Types
+// already in visited are skipped, so that recursive types terminate.
+func collectRawInfo(t reflect.Type, visited map[reflect.Type]bool, info *rawInfo) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	switch {
+	case t == rawMessageType:
+		info.raw = true
+		return
+	case t == orderedDictType:
+		return
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		info.iface = true
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		collectRawInfo(t.Elem(), visited, info)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			collectRawInfo(t.Field(i).Type, visited, info)
+		}
+	}
+}
+
+// valueLen returns the length of the encoded value at the start of data, and
+// false if data does not start with a complete, well-formed value. Lists and
+// dictionaries are tracked by depth rather than by recursion.
+func valueLen(data []byte) (int, bool) {
+	depth := 0
+	i := 0
+	for {
+		if i >= len(data) {
+			return 0, false
+		}
+		switch c := data[i]; {
+		case c == 'i':
+			end := bytes.IndexByte(data[i:], 'e')
+			if end < 0 {
+				return 0, false
+			}
+			i += end + 1
+		case c == 'l' || c == 'd':
+			depth++
+			i++
+			continue
+		case c == 'e':
+			if depth == 0 {
+				return 0, false
+			}
+			depth--
+			i++
+		case '0' <= c && c <= '9':
+			n, ok := stringLen(data[i:])
+			if !ok {
+				return 0, false
+			}
+			i += n
+		default:
+			return 0, false
+		}
+		if depth == 0 {
+			return i, true
+		}
+	}
+}
+
+// stringLen returns the length of the encoded string, with its length prefix,
+// at the start of data.
+func stringLen(data []byte) (int, bool) {
+	colon := bytes.IndexByte(data, ':')
+	if colon < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(data[:colon]))
+	if err != nil || n < 0 || n > len(data)-colon-1 {
+		return 0, false
+	}
+	return colon + 1 + n, true
+}
+
+// listSpans returns the encoded form of each element of the list encoded in
+// data.
+func listSpans(data []byte) ([][]byte, bool) {
+	if len(data) == 0 || data[0] != 'l' {
+		return nil, false
+	}
+	var spans [][]byte
+	for i := 1; i < len(data) && data[i] != 'e'; {
+		n, ok := valueLen(data[i:])
+		if !ok {
+			return nil, false
+		}
+		spans = append(spans, data[i:i+n])
+		i += n
+	}
+	return spans, true
+}
+
+// dictSpans returns the encoded form of the value of each key of the
+// dictionary encoded in data, with keys folded and duplicates handled as the
+// reader r does. A key whose value r builds from several entries, as with the
+// Collect policy, has no single encoded form and is left out.
+func (r *reader) dictSpans(data []byte) (map[string][]byte, bool) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, false
+	}
+	spans := make(map[string][]byte)
+	var collected map[string]bool
+	for i := 1; i < len(data) && data[i] != 'e'; {
+		n, ok := stringLen(data[i:])
+		if !ok {
+			return nil, false
+		}
+		key := data[i : i+n]
+		key = key[bytes.IndexByte(key, ':')+1:]
+		i += n
+		n, ok = valueLen(data[i:])
+		if !ok {
+			return nil, false
+		}
+		value := data[i : i+n]
+		i += n
+
+		k := string(key)
+		if r.foldKeys {
+			k = asciiLower(k)
+		}
+		if _, dup := spans[k]; dup || collected[k] {
+			switch r.duplicates {
+			case KeepFirst:
+				continue
+			case Collect:
+				if collected == nil {
+					collected = make(map[string]bool)
+				}
+				collected[k] = true
+				delete(spans, k)
+				continue
+			}
+		}
+		spans[k] = value
+	}
+	return spans, true
+}
+
+// A pathStep leads from a list or dictionary to a value in it: a dictionary
+// key, as it appears in the input after folding, or a list index.
+type pathStep struct {
+	key   string
+	index int // -1 for a dictionary key
+}
+
+// valueOffset returns the input offset of the value being unmarshaled, found
+// by following d.steps through d.input, or -1 if the input was not kept. A
+// step that cannot be followed, as when a value was built from several
+// entries, leaves the offset of the value enclosing it.
+func (d *Decoder) valueOffset() int64 {
+	if d.input == nil {
+		return -1
+	}
+	data := d.input
+	for _, s := range d.steps {
+		var span []byte
+		if s.index >= 0 {
+			elems, ok := listSpans(data)
+			if !ok || s.index >= len(elems) {
+				break
+			}
+			span = elems[s.index]
+		} else {
+			entries, _ := d.r.dictSpans(data)
+			var ok bool
+			if span, ok = entries[s.key]; !ok {
+				break
+			}
+		}
+		data = span
+	}
+	// data is a subslice of input, so the difference of their capacities is
+	// how far into input it starts.
+	return d.inputOffset + int64(cap(d.input)-cap(data))
+}
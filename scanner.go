@@ -0,0 +1,204 @@
+package bencode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// scanChunkSize is the most a Scanner reads of a string at once, so that a
+// huge declared length in corrupt input does not allocate memory up front.
+const scanChunkSize = 64 << 10
+
+// A Scanner splits a stream of concatenated Bencode values, such as a log file
+// that values are appended to, into the raw bytes of each top-level value.
+// Successive calls to Scan step through the values, like a bufio.Scanner.
+//
+// Values are checked against the same grammar as the decoder, including that
+// dictionary keys are strings, but are not decoded, so scanning does not build
+// any value in memory other than its bytes.
+type Scanner struct {
+	r     *reader
+	buf   []byte
+	stack []container
+	err   error
+}
+
+// NewScanner returns a new Scanner that reads from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: newReader(r)}
+}
+
+// Scan advances the Scanner to the next value, which is then available through
+// Bytes. It returns false when the input ends or is malformed, after which Err
+// reports the error, if any.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	s.buf = s.buf[:0]
+	s.stack = s.stack[:0]
+	if err := s.scanValue(); err != nil {
+		if err == io.EOF && len(s.buf) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = err
+		return false
+	}
+	return true
+}
+
+// Bytes returns the raw bytes of the value found by the most recent call to
+// Scan. The slice may be overwritten by the next call to Scan.
+func (s *Scanner) Bytes() []byte {
+	return s.buf
+}
+
+// Err returns the first error encountered by the Scanner, or nil if the input
+// ended cleanly after a complete value.
+func (s *Scanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// scanValue reads one complete value into s.buf, checking its structure as it
+// goes. Lists and dictionaries are tracked on s.stack rather than by
+// recursion, so deeply nested input cannot exhaust the goroutine stack.
+func (s *Scanner) scanValue() error {
+	for {
+		var top *container
+		if len(s.stack) > 0 {
+			top = &s.stack[len(s.stack)-1]
+		}
+
+		b, err := s.r.peek()
+		if err != nil {
+			if err == io.EOF && top != nil {
+				sentinel := ErrUnterminatedList
+				if top.delim == 'd' {
+					sentinel = ErrUnterminatedDict
+				}
+				err = fmt.Errorf("%w: %w", sentinel, io.ErrUnexpectedEOF)
+			}
+			return err
+		}
+
+		if b == 'e' {
+			if top == nil {
+				return errors.New("bencode: unexpected 'e' outside of a list or dictionary")
+			}
+			if top.delim == 'd' && !top.expectKey {
+				return errors.New("bencode: dictionary ended before the value of its last key")
+			}
+			_, _ = s.r.readByte() // Consume the 'e'
+			s.buf = append(s.buf, b)
+			s.stack = s.stack[:len(s.stack)-1]
+		} else {
+			if top != nil && top.delim == 'd' && top.expectKey && (b < '0' || b > '9') {
+				switch b {
+				case 'i':
+					return &KeyTypeError{Value: "integer", Offset: s.r.offset}
+				case 'l':
+					return &KeyTypeError{Value: "list", Offset: s.r.offset}
+				case 'd':
+					return &KeyTypeError{Value: "dictionary", Offset: s.r.offset}
+				}
+			}
+
+			switch b {
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				if err := s.scanString(); err != nil {
+					return err
+				}
+			case 'i':
+				_, _ = s.r.readByte() // Consume the 'i'
+				s.buf = append(s.buf, b)
+				if err := s.scanInt(); err != nil {
+					return err
+				}
+			case 'l', 'd':
+				_, _ = s.r.readByte() // Consume the delimiter
+				s.buf = append(s.buf, b)
+				s.stack = append(s.stack, container{delim: Delim(b), expectKey: b == 'd'})
+				continue
+			default:
+				return s.r.typeCharError(b)
+			}
+		}
+
+		// A key or value is complete, so the enclosing dictionary, if any,
+		// now expects the other.
+		if len(s.stack) == 0 {
+			return nil
+		}
+		if top := &s.stack[len(s.stack)-1]; top.delim == 'd' {
+			top.expectKey = !top.expectKey
+		}
+	}
+}
+
+// scanString reads a string, with its length prefix, into s.buf.
+func (s *Scanner) scanString() error {
+	lengthText, err := s.r.r.ReadSlice(':')
+	s.r.offset += int64(len(lengthText))
+	s.buf = append(s.buf, lengthText...)
+	if err != nil {
+		if err == io.EOF {
+			return errors.New("bencode: invalid string format, missing ':' after length")
+		}
+		if err == bufio.ErrBufferFull {
+			return errors.New("bencode: invalid string format, length is too long")
+		}
+		return fmt.Errorf("bencode: invalid string format: %w", err)
+	}
+	length, err := parseStringLength(lengthText[:len(lengthText)-1])
+	if err != nil {
+		return err
+	}
+
+	for length > 0 {
+		n := int(min(length, scanChunkSize))
+		s.buf = slices.Grow(s.buf, n)
+		read, err := io.ReadFull(s.r.r, s.buf[len(s.buf):len(s.buf)+n])
+		s.r.offset += int64(read)
+		s.buf = s.buf[:len(s.buf)+read]
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("bencode: failed to read string contents: %w", err)
+		}
+		length -= int64(n)
+	}
+	return nil
+}
+
+// scanInt reads the rest of an integer, after its 'i', into s.buf and checks
+// that it is well formed.
+func (s *Scanner) scanInt() error {
+	start := len(s.buf)
+	for {
+		text, err := s.r.r.ReadSlice('e')
+		s.r.offset += int64(len(text))
+		s.buf = append(s.buf, text...)
+		if err == nil {
+			break
+		}
+		if err == bufio.ErrBufferFull {
+			if s.r.maxIntDigits > 0 && len(s.buf)-start > s.r.maxIntDigits+1 { // Allow for a sign.
+				return fmt.Errorf("bencode: integer exceeds %d digits", s.r.maxIntDigits)
+			}
+			continue
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("bencode: invalid integer format, could not find 'e': %w", err)
+	}
+	_, err := s.r.parseInt(s.buf[start : len(s.buf)-1])
+	return err
+}
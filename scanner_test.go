@@ -0,0 +1,127 @@
+package bencode
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	values := []string{
+		"i42e",
+		"4:spam",
+		"0:",
+		"le",
+		"de",
+		"l4:spami-3eli1eee",
+		"d3:bar4:spam3:fooi42e4:listld1:ai1eeee",
+		"3:e:l",
+		"i123456789012345678901234567890e",
+		strings.Repeat("l", 5000) + strings.Repeat("e", 5000),
+		"100000:" + strings.Repeat("x", 100000),
+		multiTrackerTorrent,
+	}
+
+	// A small buffer exercises values longer than it.
+	s := NewScanner(bufio.NewReaderSize(strings.NewReader(strings.Join(values, "")), 16))
+	var got []string
+	for s.Scan() {
+		got = append(got, string(s.Bytes()))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("Scan() got %d values, want %d", len(got), len(values))
+		for i := range min(len(got), len(values)) {
+			if got[i] != values[i] {
+				t.Errorf("value %d = %.40q, want %.40q", i, got[i], values[i])
+			}
+		}
+	}
+	if s.Scan() {
+		t.Error("Scan() after the end returned true")
+	}
+}
+
+func TestScannerEmpty(t *testing.T) {
+	s := NewScanner(strings.NewReader(""))
+	if s.Scan() {
+		t.Errorf("Scan() of empty input returned true with %q", s.Bytes())
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+		// want is the number of values scanned before the error.
+		want    int
+		wantErr error
+	}{
+		{name: "Truncated List", in: "i1el4:spam", want: 1, wantErr: io.ErrUnexpectedEOF},
+		{name: "Unterminated List", in: "i1el4:spam", want: 1, wantErr: ErrUnterminatedList},
+		{name: "Unterminated Dictionary", in: "ld3:fooi1e", wantErr: ErrUnterminatedDict},
+		{name: "Truncated String", in: "5:abc", wantErr: io.ErrUnexpectedEOF},
+		{name: "Truncated Integer", in: "i1ei42", want: 1, wantErr: io.ErrUnexpectedEOF},
+		{name: "Integer Key", in: "4:spamdi1e3:fooe", want: 1},
+		{name: "Lone End", in: "lee", want: 1},
+		{name: "Missing Value", in: "d3:fooe"},
+		{name: "Bad Integer", in: "i4x2e"},
+		{name: "Bad Type", in: "i1ex", want: 1},
+		{name: "Negative Length", in: "-1:x"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewScanner(strings.NewReader(tc.in))
+			n := 0
+			for s.Scan() {
+				n++
+			}
+			if n != tc.want {
+				t.Errorf("Scan() returned %d values before failing, want %d", n, tc.want)
+			}
+			err := s.Err()
+			if err == nil {
+				t.Fatal("Err() = nil, want an error")
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("Err() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	s := NewScanner(strings.NewReader("d4:spami1ei2ei3ee"))
+	var keyErr *KeyTypeError
+	if s.Scan() || !errors.As(s.Err(), &keyErr) || keyErr.Offset != 10 {
+		t.Errorf("Err() = %v, want a *KeyTypeError at offset 10", s.Err())
+	}
+}
+
+func TestScannerDecode(t *testing.T) {
+	// Each value scanned can be decoded by itself.
+	s := NewScanner(strings.NewReader("d3:fooi1eed3:fooi2eed3:fooi3ee"))
+	var got []int
+	for s.Scan() {
+		var v struct {
+			Foo int `bencode:"foo"`
+		}
+		if err := Unmarshal(s.Bytes(), &v); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		got = append(got, v.Foo)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package bencode
+
+import (
+	"reflect"
+	"strings"
+)
+
+// defaultTagName is the struct tag key read unless another is configured.
+const defaultTagName = "bencode"
+
+// tagOptions is the string following a comma in a struct field's "bencode"
+// tag, or the empty string.
+type tagOptions string
+
+// parseTag splits a struct field's bencode tag into its name and
+// comma-separated options.
+func parseTag(tag string) (string, tagOptions) {
+	name, opt, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opt)
+}
+
+// Contains reports whether a comma-separated list of options
+// contains a particular optionName flag.
+func (o tagOptions) Contains(optionName string) bool {
+	_, ok := o.lookup(optionName)
+	return ok
+}
+
+// Get returns the value of a key=value option and whether it was present.
+func (o tagOptions) Get(key string) (string, bool) {
+	return o.lookup(key)
+}
+
+// lookup finds the option with the given name, returning the text after
+// its '=' if it has one.
+func (o tagOptions) lookup(optionName string) (string, bool) {
+	s := string(o)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		name, value, _ := strings.Cut(opt, "=")
+		if name == optionName {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// fieldKey returns the dictionary key and tag options for a struct field.
+// The key is taken from the tag with the given name, or the field name if
+// there is none. A tag of "-" reports ok == false, meaning the field is ignored.
+func fieldKey(field reflect.StructField, tagName string) (key string, opts tagOptions, ok bool) {
+	key, opts = parseTag(field.Tag.Get(tagName))
+	if key == "-" {
+		return "", "", false
+	}
+	if key == "" {
+		key = field.Name // Default to field name if no tag
+	}
+	return key, opts, true
+}
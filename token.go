@@ -0,0 +1,102 @@
+package bencode
+
+import "errors"
+
+// A Token holds a value of one of these types:
+//
+//   - Delim, for the start of a list ('l') or dictionary ('d'), or the end of either ('e')
+//   - string, for Bencode strings, including dictionary keys
+//   - int64, for Bencode integers, or *big.Int if the integer does not fit in an int64
+//   - Number, for Bencode integers, instead of int64 and *big.Int, after UseNumber
+//   - int, for Bencode integers that fit in an int, after UseInt, with *big.Int for the rest
+type Token any
+
+// A Delim is a Bencode list or dictionary delimiter: 'l', 'd' or 'e'.
+type Delim byte
+
+func (d Delim) String() string {
+	return string(d)
+}
+
+// container records a list or dictionary opened by Token that has not yet
+// been closed.
+type container struct {
+	delim     Delim
+	expectKey bool // For dictionaries, whether the next token must be a key.
+}
+
+// Token returns the next Bencode token in the input stream.
+// At the end of the input stream, Token returns nil, io.EOF.
+//
+// Token checks that the delimiters it returns are properly nested and that
+// dictionary keys are strings. It enables hand-written streaming parsers that
+// walk large inputs without reflection or building the full value in memory.
+// Calls to Token and Decode may be mixed, with Decode reading a complete value
+// at the current position.
+func (d *Decoder) Token() (Token, error) {
+	b, err := d.r.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	var top *container
+	if len(d.tokens) > 0 {
+		top = &d.tokens[len(d.tokens)-1]
+	}
+
+	if b == 'e' {
+		if top == nil {
+			return nil, errors.New("bencode: unexpected 'e' outside of a list or dictionary")
+		}
+		if top.delim == 'd' && !top.expectKey {
+			return nil, errors.New("bencode: dictionary ended before the value of its last key")
+		}
+		_, _ = d.r.readByte() // Consume the 'e'
+		d.tokens = d.tokens[:len(d.tokens)-1]
+		d.tokenAdvance()
+		return Delim('e'), nil
+	}
+
+	if top != nil && top.delim == 'd' && top.expectKey {
+		key, err := d.r.decodeKey()
+		if err != nil {
+			return nil, err
+		}
+		d.tokenAdvance()
+		return key, nil
+	}
+
+	switch b {
+	case 'l', 'd':
+		_, _ = d.r.readByte() // Consume the delimiter
+		d.tokens = append(d.tokens, container{delim: Delim(b), expectKey: b == 'd'})
+		return Delim(b), nil
+	case 'i':
+		i, err := d.r.decodeInt()
+		if err != nil {
+			return nil, err
+		}
+		d.tokenAdvance()
+		return i, nil
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		s, err := d.r.decodeString()
+		if err != nil {
+			return nil, err
+		}
+		d.tokenAdvance()
+		return s, nil
+	default:
+		return nil, d.r.typeCharError(b)
+	}
+}
+
+// tokenAdvance records that a complete key or value has been read, so that
+// the enclosing dictionary, if any, alternates between expecting a key and
+// expecting its value.
+func (d *Decoder) tokenAdvance() {
+	if len(d.tokens) > 0 {
+		if top := &d.tokens[len(d.tokens)-1]; top.delim == 'd' {
+			top.expectKey = !top.expectKey
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package bencode
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildValue reconstructs a generic value from the tokens read by d.
+func buildValue(d *Decoder) (any, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case Delim('l'):
+		list := make([]any, 0)
+		for {
+			item, err := buildValue(d)
+			if err != nil {
+				return nil, err
+			}
+			if item == Delim('e') {
+				return list, nil
+			}
+			list = append(list, item)
+		}
+	case Delim('d'):
+		dict := make(map[string]any)
+		for {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if key == Delim('e') {
+				return dict, nil
+			}
+			value, err := buildValue(d)
+			if err != nil {
+				return nil, err
+			}
+			dict[key.(string)] = value
+		}
+	default:
+		return tok, nil
+	}
+}
+
+func TestDecoderToken(t *testing.T) {
+	const in = "d4:dictd3:key5:valuee4:listli1ei2ei3eee"
+
+	d := NewDecoder(strings.NewReader(in))
+	got, err := buildValue(d)
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	var want any
+	if err := Unmarshal([]byte(in), &want); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens rebuilt %#v, want %#v", got, want)
+	}
+
+	if tok, err := d.Token(); tok != nil || err != io.EOF {
+		t.Errorf("Token() at end = %v, %v, want nil, io.EOF", tok, err)
+	}
+}
+
+func TestDecoderTokenSequence(t *testing.T) {
+	d := NewDecoder(strings.NewReader("l4:spami-3edee"))
+	want := []Token{Delim('l'), "spam", int64(-3), Delim('d'), Delim('e'), Delim('e')}
+
+	for i, w := range want {
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatalf("Token() #%d error = %v", i, err)
+		}
+		if tok != w {
+			t.Errorf("Token() #%d = %#v, want %#v", i, tok, w)
+		}
+	}
+}
+
+func TestDecoderTokenMixedWithDecode(t *testing.T) {
+	d := NewDecoder(strings.NewReader("d4:infod4:name3:fooe4:sizei7ee"))
+
+	if tok, err := d.Token(); err != nil || tok != Delim('d') {
+		t.Fatalf("Token() = %v, %v, want 'd'", tok, err)
+	}
+	if tok, err := d.Token(); err != nil || tok != "info" {
+		t.Fatalf("Token() = %v, %v, want info", tok, err)
+	}
+
+	var info struct {
+		Name string `bencode:"name"`
+	}
+	if err := d.Decode(&info); err != nil || info.Name != "foo" {
+		t.Fatalf("Decode() got %#v, error = %v", info, err)
+	}
+
+	for _, w := range []Token{"size", int64(7), Delim('e')} {
+		if tok, err := d.Token(); err != nil || tok != w {
+			t.Errorf("Token() = %#v, %v, want %#v", tok, err, w)
+		}
+	}
+}
+
+func TestDecoderTokenError(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   string
+	}{
+		{name: "Lone End", in: "e"},
+		{name: "Non-String Key", in: "di1ei2ee"},
+		{name: "Missing Value", in: "d3:fooe"},
+		{name: "Invalid Type", in: "x"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if errors.Is(err, io.EOF) {
+				t.Errorf("Token() reached EOF without an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package bencode
+
+import "time"
+
+// Torrent holds the common fields of a .torrent metainfo file, as described in
+// BEP 3, with the multi-tracker announce-list of BEP 12. Keys it does not
+// declare are kept in the Extra maps of the torrent, its info dictionary and
+// its files, and optional integers are pointers, so that a key present with
+// a value of zero is told apart from one that is missing.
+//
+// Even so, re-encoding a Torrent need not reproduce the original bytes: keys
+// are written in sorted order, and optional strings and lists that are empty
+// are left out. The info hash must therefore never be computed from a
+// re-encoded Torrent. Decode the torrent again into a struct whose info field is a
+// RawMessage, which keeps the original bytes, and hash those.
+type Torrent struct {
+	Announce     string         `bencode:"announce,omitempty"`
+	AnnounceList [][]string     `bencode:"announce-list,omitempty"`
+	Comment      string         `bencode:"comment,omitempty"`
+	CreatedBy    string         `bencode:"created by,omitempty"`
+	CreationDate *time.Time     `bencode:"creation date,omitempty"`
+	Info         TorrentInfo    `bencode:"info"`
+	Extra        map[string]any `bencode:",extra"`
+}
+
+// TorrentInfo is the info dictionary of a Torrent. A single-file torrent has
+// a Length, while a multi-file torrent has Files, and Name is then the name of
+// the directory holding them.
+//
+// PieceLength is almost always a power of two, but BEP 3 does not require it,
+// so it is not checked. Declare the field with the "pow2" option in a struct
+// of your own to reject other values.
+type TorrentInfo struct {
+	Name        string         `bencode:"name"`
+	PieceLength int64          `bencode:"piece length"`
+	Pieces      []byte         `bencode:"pieces"`
+	Private     *int           `bencode:"private,omitempty"`
+	Length      *int64         `bencode:"length,omitempty"`
+	Files       []TorrentFile  `bencode:"files,omitempty"`
+	Extra       map[string]any `bencode:",extra"`
+}
+
+// TorrentFile is a file in a multi-file torrent. Path holds the components
+// of its path within the torrent's directory, and Extra any keys, such as
+// md5sum, that are not declared.
+type TorrentFile struct {
+	Length int64          `bencode:"length"`
+	Path   []string       `bencode:"path"`
+	Extra  map[string]any `bencode:",extra"`
+}
+
+// Trackers returns the torrent's tracker URLs in tiers, in the order they
+// should be tried. As BEP 12 specifies, the announce-list is used if present,
+// and otherwise the announce URL forms the only tier. Empty tiers are dropped.
+func (t *Torrent) Trackers() [][]string {
+	var tiers [][]string
+	for _, tier := range t.AnnounceList {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	if len(tiers) == 0 && t.Announce != "" {
+		tiers = [][]string{{t.Announce}}
+	}
+	return tiers
+}
@@ -0,0 +1,178 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// multiTrackerTorrent is a multi-file torrent with an announce-list of two
+// tiers, the first holding two trackers, as well as a key Torrent does not
+// declare.
+const multiTrackerTorrent = "d" +
+	"8:announce35:http://tracker.example.com/announce" +
+	"13:announce-list" +
+	"l" +
+	"l35:http://tracker.example.com/announce29:udp://backup.example.org:6969" +
+	"e" +
+	"l30:http://tier2.example.net/annce" +
+	"e" +
+	"e" +
+	"7:comment7:example" +
+	"13:creation datei1700000000e" +
+	"4:infod" +
+	"5:filesl" +
+	"d6:lengthi1024e4:pathl3:dir5:a.txtee" +
+	"d6:lengthi2048e4:pathl5:b.txtee" +
+	"e" +
+	"4:name7:example" +
+	"12:piece lengthi16384e" +
+	"6:pieces20:" + "01234567890123456789" +
+	"e" +
+	"8:url-listl22:http://web.example.comee"
+
+func TestTorrent(t *testing.T) {
+	var got Torrent
+	if err := Unmarshal([]byte(multiTrackerTorrent), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	created := time.Unix(1700000000, 0).UTC()
+	want := Torrent{
+		Announce: "http://tracker.example.com/announce",
+		AnnounceList: [][]string{
+			{"http://tracker.example.com/announce", "udp://backup.example.org:6969"},
+			{"http://tier2.example.net/annce"},
+		},
+		Comment:      "example",
+		CreationDate: &created,
+		Info: TorrentInfo{
+			Name:        "example",
+			PieceLength: 16384,
+			Pieces:      []byte("01234567890123456789"),
+			Files: []TorrentFile{
+				{Length: 1024, Path: []string{"dir", "a.txt"}},
+				{Length: 2048, Path: []string{"b.txt"}},
+			},
+		},
+		Extra: map[string]any{"url-list": []any{"http://web.example.com"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	if trackers := got.Trackers(); !reflect.DeepEqual(trackers, want.AnnounceList) {
+		t.Errorf("Trackers() = %v, want %v", trackers, want.AnnounceList)
+	}
+
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != multiTrackerTorrent {
+		t.Errorf("Marshal() got = %q, want %q", out, multiTrackerTorrent)
+	}
+}
+
+func TestTorrentTrackers(t *testing.T) {
+	testCases := []struct {
+		name    string
+		torrent Torrent
+		want    [][]string
+	}{
+		{name: "Announce Only", torrent: Torrent{Announce: "a"}, want: [][]string{{"a"}}},
+		{
+			name:    "Announce List Preferred",
+			torrent: Torrent{Announce: "a", AnnounceList: [][]string{{"b"}, {"c", "d"}}},
+			want:    [][]string{{"b"}, {"c", "d"}},
+		},
+		{name: "Empty Tiers", torrent: Torrent{Announce: "a", AnnounceList: [][]string{{}}}, want: [][]string{{"a"}}},
+		{name: "None", torrent: Torrent{}, want: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.torrent.Trackers(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Trackers() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTorrentPieceLength(t *testing.T) {
+	// BEP 3 does not require a power of two, so other piece lengths decode.
+	in := strings.Replace(multiTrackerTorrent, "i16384e", "i16000e", 1)
+	var got Torrent
+	if err := Unmarshal([]byte(in), &got); err != nil || got.Info.PieceLength != 16000 {
+		t.Errorf("Unmarshal() piece length = %d, error = %v, want 16000, nil", got.Info.PieceLength, err)
+	}
+}
+
+func TestTorrentRoundTrip(t *testing.T) {
+	// Zero values that are present, and keys Torrent does not declare at any
+	// level, survive decoding and re-encoding a canonical torrent.
+	testCases := []struct {
+		name string
+		in   string
+	}{
+		{
+			name: "Single File",
+			in:   "d4:infod6:lengthi0e4:name1:a12:piece lengthi1000e6:pieces0:7:privatei0eee",
+		},
+		{
+			name: "File Keys",
+			in: "d4:infod5:filesl" +
+				"d4:attr1:x6:lengthi1e6:md5sum32:0123456789abcdef0123456789abcdef4:pathl1:aee" +
+				"e4:name1:d12:piece lengthi16384e6:pieces0:e" +
+				"8:url-listl0:ee",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var torrent Torrent
+			if err := Unmarshal([]byte(tc.in), &torrent); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			out, err := Marshal(torrent)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(out) != tc.in {
+				t.Errorf("Marshal() got = %q, want %q", out, tc.in)
+			}
+		})
+	}
+}
+
+func TestTorrentInfoHash(t *testing.T) {
+	// An info dictionary with its keys out of order hashes as it was written,
+	// not as Marshal would write it.
+	const info = "d4:name1:a12:piece lengthi16384e6:lengthi1e6:pieces20:01234567890123456789e"
+	data := []byte("d8:announce3:url4:info" + info + "e")
+
+	var torrent Torrent
+	if err := Unmarshal(data, &torrent); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	var raw struct {
+		Info RawMessage `bencode:"info"`
+	}
+	if err := Unmarshal(data, &raw); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if sha1.Sum(raw.Info) != sha1.Sum([]byte(info)) {
+		t.Errorf("Info = %q, want %q", raw.Info, info)
+	}
+
+	// Re-encoding the decoded Torrent would give a different hash.
+	canonical, err := MarshalCanonical(torrent.Info)
+	if err != nil {
+		t.Fatalf("MarshalCanonical() error = %v", err)
+	}
+	if sha1.Sum(canonical) == sha1.Sum([]byte(info)) {
+		t.Errorf("MarshalCanonical() got = %q, want it to differ from the original", canonical)
+	}
+}
@@ -1,19 +1,63 @@
 package bencode
 
 import (
+	"bytes"
+	"encoding"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
 )
 
+var (
+	rawMessageType   = reflect.TypeFor[RawMessage]()
+	stringReaderType = reflect.TypeFor[StringReader]()
+	orderedDictType  = reflect.TypeFor[OrderedDict]()
+	timeType         = reflect.TypeFor[time.Time]()
+	durationType     = reflect.TypeFor[time.Duration]()
+	bigIntType       = reflect.TypeFor[big.Int]()
+	numberType       = reflect.TypeFor[Number]()
+
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// asciiSpace holds the ASCII whitespace characters removed by TrimStringFields.
+const asciiSpace = " \t\n\v\f\r"
+
 // unmarshal populates the reflect.Value v with the data from rawData.
 // v must be a settable value (a pointer or a settable field).
-func unmarshal(rawData any, v reflect.Value) error {
-	// If v is a pointer, set the value it points to.
-	if v.Kind() == reflect.Pointer {
+func (d *Decoder) unmarshal(rawData any, v reflect.Value) error {
+	// If v is a pointer, set the value it points to, following any further
+	// pointers. An interface holding a non-nil pointer is followed too, as in
+	// encoding/json, so that the value is decoded into what it points to
+	// rather than replacing it.
+	for {
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			if e := v.Elem(); e.Kind() == reflect.Pointer && !e.IsNil() {
+				v = e
+				continue
+			}
+		}
+		if v.Kind() != reflect.Pointer {
+			break
+		}
 		// If the pointer is nil, create a new value for it to point to.
 		if v.IsNil() {
 			v.Set(reflect.New(v.Type().Elem()))
 		}
+		// A pointer to an interface that holds the pointer itself would be
+		// followed forever, so the interface is decoded into instead.
+		if v.Elem().Kind() == reflect.Interface && v.Elem().Elem().Equal(v) {
+			v = v.Elem()
+			break
+		}
 		// Dereference the pointer.
 		v = v.Elem()
 	}
@@ -23,99 +67,388 @@ func unmarshal(rawData any, v reflect.Value) error {
 		return nil
 	}
 
+	// With UseNumber, integers are only kept as a Number when decoded into an
+	// interface or a Number. Everything else sees the usual int64 or *big.Int.
+	if n, ok := rawData.(Number); ok && v.Kind() != reflect.Interface && v.Type() != numberType {
+		value, err := n.value()
+		if err != nil {
+			return err
+		}
+		rawData = value
+	}
+
+	// With UseInt, integers are only kept as an int when decoded into an
+	// interface. Everything else sees the usual int64, including for values
+	// that UseInt made a *big.Int because they do not fit a 32-bit int.
+	if v.Kind() != reflect.Interface {
+		switch n := rawData.(type) {
+		case int:
+			rawData = int64(n)
+		case *big.Int:
+			if n.IsInt64() {
+				rawData = n.Int64()
+			}
+		}
+	}
+
+	// With UseBytes, strings are only kept as a []byte when decoded into an
+	// interface. The bytes were read for this value alone, so the string can
+	// share their memory, unless they came from an arena, whose memory is
+	// reused.
+	if b, ok := rawData.([]byte); ok && v.Kind() != reflect.Interface {
+		if d.r.arena != nil {
+			rawData = string(b)
+		} else {
+			rawData = unsafe.String(unsafe.SliceData(b), len(b))
+		}
+	}
+
+	// With UseOrderedDict, dictionaries are only kept as an *OrderedDict when
+	// decoded into an interface or an OrderedDict.
+	if od, ok := rawData.(*OrderedDict); ok && v.Kind() != reflect.Interface && v.Type() != orderedDictType {
+		rawData = od.values
+		if od.values == nil {
+			rawData = map[string]any{}
+		}
+	}
+
+	// The decode hook sees the value in the same form as the conversions
+	// below. A result of exactly the target type is stored as it is, and
+	// anything else is converted in its place.
+	if d.hook != nil {
+		hooked, err := d.hook(v.Type(), rawData)
+		if err != nil {
+			if len(d.path) > 0 {
+				return fmt.Errorf("bencode: decode hook for %s at key %q: %w", v.Type(), strings.Join(d.path, "."), err)
+			}
+			return fmt.Errorf("bencode: decode hook for %s: %w", v.Type(), err)
+		}
+		if hooked == nil {
+			return nil
+		}
+		if hv := reflect.ValueOf(hooked); hv.Type() == v.Type() {
+			v.Set(hv)
+			return nil
+		}
+		rawData = hooked
+		d.raw = nil
+	}
+
+	// RawMessage captures the encoded value as-is. OrderedDict is filled in
+	// input order with UseOrderedDict, and in sorted key order otherwise, as
+	// the input order is not kept. time.Time is decoded
+	// from a Unix timestamp in seconds, time.Duration from a number of seconds,
+	// and big.Int and Number from an integer of any size. These must be checked
+	// before the generic kind dispatch, which would otherwise treat a Duration
+	// as nanoseconds, a big.Int as a struct and a Number as a string.
+	switch v.Type() {
+	case rawMessageType:
+		if d.raw != nil {
+			v.SetBytes(bytes.Clone(d.raw))
+			return nil
+		}
+		raw, err := Marshal(rawData)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+
+	case orderedDictType:
+		if src, ok := rawData.(*OrderedDict); ok {
+			od := v.Addr().Interface().(*OrderedDict)
+			*od = OrderedDict{}
+			for _, key := range src.keys {
+				od.Set(key, src.values[key])
+			}
+			return nil
+		}
+		rawMap, ok := rawData.(map[string]any)
+		if !ok {
+			return d.typeError(rawData, v.Type())
+		}
+		keys := make([]string, 0, len(rawMap))
+		for key := range rawMap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		od := v.Addr().Interface().(*OrderedDict)
+		*od = OrderedDict{}
+		for _, key := range keys {
+			od.Set(key, rawMap[key])
+		}
+		return nil
+
+	case timeType:
+		i, ok := rawData.(int64)
+		if !ok {
+			return d.typeError(rawData, v.Type())
+		}
+		v.Set(reflect.ValueOf(time.Unix(i, 0).UTC()))
+		return nil
+
+	case durationType:
+		i, ok := rawData.(int64)
+		if !ok {
+			return d.typeError(rawData, v.Type())
+		}
+		if i > math.MaxInt64/int64(time.Second) || i < math.MinInt64/int64(time.Second) {
+			return d.rangeError(strconv.FormatInt(i, 10), v.Type())
+		}
+		v.SetInt(i * int64(time.Second))
+		return nil
+
+	case bigIntType:
+		b := v.Addr().Interface().(*big.Int)
+		switch i := rawData.(type) {
+		case int64:
+			b.SetInt64(i)
+		case *big.Int:
+			b.Set(i)
+		default:
+			return d.typeError(rawData, v.Type())
+		}
+		return nil
+
+	case numberType:
+		switch i := rawData.(type) {
+		case int64:
+			v.SetString(strconv.FormatInt(i, 10))
+		case *big.Int:
+			v.SetString(i.String())
+		case Number:
+			v.SetString(string(i))
+		default:
+			return d.typeError(rawData, v.Type())
+		}
+		return nil
+	}
+
+	// Types implementing encoding.TextUnmarshaler, such as net.IP, decode
+	// bencode strings themselves.
+	if s, ok := rawData.(string); ok && v.CanAddr() {
+		if tu, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return tu.UnmarshalText([]byte(s))
+		}
+	}
+
 	switch v.Kind() {
 	case reflect.String:
 		s, ok := rawData.(string)
 		if !ok {
-			return fmt.Errorf("bencode: cannot unmarshal %T into Go value of type string", rawData)
+			return d.typeError(rawData, v.Type())
+		}
+		if d.trimStrings {
+			s = strings.Trim(s, asciiSpace)
 		}
 		v.SetString(s)
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if b, ok := rawData.(*big.Int); ok {
+			return d.rangeError(b.String(), v.Type())
+		}
 		i, ok := rawData.(int64)
 		if !ok {
-			return fmt.Errorf("bencode: cannot unmarshal %T into Go value of type int64", rawData)
+			return d.typeError(rawData, v.Type())
 		}
 		if v.OverflowInt(i) {
-			return fmt.Errorf("bencode: value %d overflows Go value of type %s", i, v.Type())
+			return d.rangeError(strconv.FormatInt(i, 10), v.Type())
 		}
 		v.SetInt(i)
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// Integers above math.MaxInt64 are decoded as a *big.Int, but may
+		// still fit in a uint64.
+		if b, ok := rawData.(*big.Int); ok {
+			if !b.IsUint64() || v.OverflowUint(b.Uint64()) {
+				return d.rangeError(b.String(), v.Type())
+			}
+			v.SetUint(b.Uint64())
+			return nil
+		}
 		i, ok := rawData.(int64)
 		if !ok {
-			return fmt.Errorf("bencode: cannot unmarshal %T into Go value of type uint64", rawData)
+			return d.typeError(rawData, v.Type())
 		}
 		if i < 0 {
-			return fmt.Errorf("bencode: cannot unmarshal negative value %d into unsigned Go type %s", i, v.Type())
+			return d.rangeError(strconv.FormatInt(i, 10), v.Type())
 		}
 		if v.OverflowUint(uint64(i)) {
-			return fmt.Errorf("bencode: value %d overflows Go value of type %s", i, v.Type())
+			return d.rangeError(strconv.FormatInt(i, 10), v.Type())
 		}
 		v.SetUint(uint64(i))
 
 	case reflect.Slice:
+		// Byte slices are decoded from bencode strings.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if s, ok := rawData.(string); ok {
+				v.SetBytes([]byte(s))
+				return nil
+			}
+		}
 		rawSlice, ok := rawData.([]any)
 		if !ok {
-			return fmt.Errorf("bencode: cannot unmarshal %T into Go value of type slice", rawData)
+			return d.typeError(rawData, v.Type())
 		}
+		raw, elems := d.raw, d.rawElems(len(rawSlice))
 		slice := reflect.MakeSlice(v.Type(), len(rawSlice), len(rawSlice))
 		for i, item := range rawSlice {
-			if err := unmarshal(item, slice.Index(i)); err != nil {
+			d.raw = elemRaw(elems, i)
+			d.steps = append(d.steps, pathStep{index: i})
+			err := d.unmarshal(item, slice.Index(i))
+			d.steps = d.steps[:len(d.steps)-1]
+			if err != nil {
 				return err
 			}
 		}
+		d.raw = raw
 		v.Set(slice)
 
+	case reflect.Array:
+		// Arrays, such as [20]byte for a SHA-1 hash, must be given exactly
+		// as many bytes or elements as they hold.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if s, ok := rawData.(string); ok {
+				if len(s) != v.Len() {
+					return d.valueError("string of length "+strconv.Itoa(len(s)), v.Type())
+				}
+				reflect.Copy(v, reflect.ValueOf(s))
+				return nil
+			}
+		}
+		rawSlice, ok := rawData.([]any)
+		if !ok {
+			return d.typeError(rawData, v.Type())
+		}
+		if len(rawSlice) != v.Len() {
+			return d.valueError("list of length "+strconv.Itoa(len(rawSlice)), v.Type())
+		}
+		raw, elems := d.raw, d.rawElems(len(rawSlice))
+		for i, item := range rawSlice {
+			d.raw = elemRaw(elems, i)
+			d.steps = append(d.steps, pathStep{index: i})
+			err := d.unmarshal(item, v.Index(i))
+			d.steps = d.steps[:len(d.steps)-1]
+			if err != nil {
+				return err
+			}
+		}
+		d.raw = raw
+
 	case reflect.Struct:
 		rawMap, ok := rawData.(map[string]any)
 		if !ok {
-			return fmt.Errorf("bencode: cannot unmarshal %T into Go value of type struct", rawData)
+			return d.typeError(rawData, v.Type())
 		}
-		for i := 0; i < v.NumField(); i++ {
-			field := v.Type().Field(i)
-			// Skip unexported fields.
-			if field.PkgPath != "" {
-				continue
+		fields := structFields(v.Type(), d.tagName)
+
+		// A field tagged with the "extra" option collects the keys that no
+		// other field matches, so the keys that are matched must be tracked.
+		extra := -1
+		var matched map[string]bool
+		for i, f := range fields {
+			if f.opts.Contains("extra") {
+				extra = i
+				matched = make(map[string]bool, len(fields))
+				break
 			}
+		}
 
-			tag := field.Tag.Get("bencode")
-			if tag == "" {
-				tag = field.Name // Default to field name if no tag
+		raw, entries := d.raw, d.rawEntries()
+		for i, f := range fields {
+			if i == extra {
+				continue
+			}
+			// A field tagged with the "decodedAt" option is stamped with the
+			// time of decoding rather than taken from the input.
+			if f.opts.Contains("decodedAt") {
+				fv := fieldByIndexAlloc(v, f.index)
+				if fv.Type() != timeType {
+					return fmt.Errorf("bencode: decodedAt field %s.%s must be of type time.Time", v.Type(), f.key)
+				}
+				fv.Set(reflect.ValueOf(time.Now()))
+				continue
 			}
 
-			if rawValue, ok := rawMap[tag]; ok {
-				if err := unmarshal(rawValue, v.Field(i)); err != nil {
+			if key, rawValue, ok := d.lookupKey(rawMap, f.key); ok {
+				if matched != nil {
+					matched[key] = true
+				}
+				d.path = append(d.path, f.key)
+				d.steps = append(d.steps, pathStep{key: key, index: -1})
+				d.raw = entries[key]
+				err := d.unmarshalField(rawValue, v, fieldByIndexAlloc(v, f.index), f.opts)
+				d.path = d.path[:len(d.path)-1]
+				d.steps = d.steps[:len(d.steps)-1]
+				if err != nil {
 					return err
 				}
+			} else if f.opts.Contains("required") {
+				return fmt.Errorf("bencode: missing required key %q", strings.Join(append(d.path, f.key), "."))
 			}
 		}
 
+		d.raw = raw
+
+		if extra >= 0 {
+			return d.unmarshalExtra(rawMap, matched, v, fields[extra])
+		}
+
 	case reflect.Map:
 		rawMap, ok := rawData.(map[string]any)
 		if !ok {
-			return fmt.Errorf("bencode: cannot unmarshal %T into Go value of type map", rawData)
+			return d.typeError(rawData, v.Type())
+		}
+		kt := v.Type().Key()
+		if kt.Kind() != reflect.String && !isIntKind(kt.Kind()) && !reflect.PointerTo(kt).Implements(textUnmarshalerType) {
+			return fmt.Errorf("bencode: unsupported map key type for unmarshaling: %s", kt)
 		}
 		if v.IsNil() {
 			v.Set(reflect.MakeMap(v.Type()))
 		}
+		raw, entries := d.raw, d.rawEntries()
 		for key, rawValue := range rawMap {
+			d.path = append(d.path, key)
+			d.steps = append(d.steps, pathStep{key: key, index: -1})
+			d.raw = entries[key]
+			mapKey, err := d.mapKey(key, kt)
+			if err != nil {
+				d.path = d.path[:len(d.path)-1]
+				d.steps = d.steps[:len(d.steps)-1]
+				return err
+			}
 			mapValue := reflect.New(v.Type().Elem()).Elem()
-			if err := unmarshal(rawValue, mapValue); err != nil {
+			err = d.unmarshal(rawValue, mapValue)
+			d.path = d.path[:len(d.path)-1]
+			d.steps = d.steps[:len(d.steps)-1]
+			if err != nil {
 				return err
 			}
-			v.SetMapIndex(reflect.ValueOf(key), mapValue)
+			v.SetMapIndex(mapKey, mapValue)
 		}
+		d.raw = raw
 
 	case reflect.Interface:
+		if v.IsNil() && d.typeKey != "" {
+			if factory, ok := d.registeredType(rawData); ok {
+				return d.unmarshalRegistered(rawData, v, factory)
+			}
+		}
+		newValue := reflect.ValueOf(rawData)
 		if !v.IsNil() {
 			currentType := v.Elem().Type()
-			newValue := reflect.ValueOf(rawData)
 			if !newValue.Type().AssignableTo(currentType) {
-				return fmt.Errorf("bencode: cannot unmarshal %T into value of type %s", rawData, currentType)
+				return d.typeError(rawData, currentType)
 			}
 		}
-		v.Set(reflect.ValueOf(rawData))
+		// The generic form of a value, such as a string or map[string]any, is
+		// stored as it is, so an interface with methods, which gives no
+		// concrete type to decode into, can only hold values that implement it.
+		if !newValue.Type().AssignableTo(v.Type()) {
+			return d.typeError(rawData, v.Type())
+		}
+		v.Set(newValue)
 
 	default:
 		return fmt.Errorf("bencode: unsupported type for unmarshaling: %s", v.Kind())
@@ -123,3 +456,333 @@ func unmarshal(rawData any, v reflect.Value) error {
 
 	return nil
 }
+
+// lookupKey returns the key in rawMap matching a struct field's key, and its
+// value. An exact match is always preferred. With case-insensitive matching
+// enabled, a key differing only in case is used otherwise, picking the
+// smallest such key if there are several so the result does not depend on map
+// iteration order.
+func (d *Decoder) lookupKey(rawMap map[string]any, key string) (string, any, bool) {
+	if rawValue, ok := rawMap[key]; ok || !d.caseInsensitive {
+		return key, rawValue, ok
+	}
+
+	var match string
+	found := false
+	for k := range rawMap {
+		if strings.EqualFold(k, key) && (!found || k < match) {
+			match, found = k, true
+		}
+	}
+	return match, rawMap[match], found
+}
+
+// mapKey converts the dictionary key into a map key of type t, which is of
+// string or integer kind, or implements encoding.TextUnmarshaler through a
+// pointer. As in encoding/json, TextUnmarshaler takes precedence.
+//
+// A key for an integer type must be written exactly as Marshal writes it, so
+// keys such as "01" or "+1" are rejected rather than mapped to the same entry
+// as "1", where which value won would depend on map iteration order.
+func (d *Decoder) mapKey(key string, t reflect.Type) (reflect.Value, error) {
+	kv := reflect.New(t)
+	if tu, ok := kv.Interface().(encoding.TextUnmarshaler); ok {
+		if err := tu.UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, err
+		}
+		return kv.Elem(), nil
+	}
+
+	kv = kv.Elem()
+	switch t.Kind() {
+	case reflect.String:
+		kv.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || kv.OverflowInt(i) || strconv.FormatInt(i, 10) != key {
+			return reflect.Value{}, d.typeError(key, t)
+		}
+		kv.SetInt(i)
+	default:
+		u, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || kv.OverflowUint(u) || strconv.FormatUint(u, 10) != key {
+			return reflect.Value{}, d.typeError(key, t)
+		}
+		kv.SetUint(u)
+	}
+	return kv, nil
+}
+
+// rawElems returns the encoded form of each of the n elements of the list
+// being unmarshaled, or nil if it is not known.
+func (d *Decoder) rawElems(n int) [][]byte {
+	if d.raw == nil {
+		return nil
+	}
+	elems, ok := listSpans(d.raw)
+	if !ok || len(elems) != n {
+		// Lenient decoding dropped a malformed element.
+		return nil
+	}
+	return elems
+}
+
+// elemRaw returns the encoded form of element i from the result of rawElems.
+func elemRaw(elems [][]byte, i int) []byte {
+	if elems == nil {
+		return nil
+	}
+	return elems[i]
+}
+
+// rawEntries returns the encoded form of the value of each key of the
+// dictionary being unmarshaled, or nil if it is not known.
+func (d *Decoder) rawEntries() map[string][]byte {
+	if d.raw == nil {
+		return nil
+	}
+	entries, _ := d.r.dictSpans(d.raw)
+	return entries
+}
+
+// registeredType returns the factory registered for the type named by the type
+// key of rawData, if rawData is a dictionary with such a key.
+func (d *Decoder) registeredType(rawData any) (func() any, bool) {
+	var name any
+	switch m := rawData.(type) {
+	case map[string]any:
+		name = m[d.typeKey]
+	case *OrderedDict:
+		name, _ = m.Get(d.typeKey)
+	default:
+		return nil, false
+	}
+	var s string
+	switch name := name.(type) {
+	case string:
+		s = name
+	case []byte:
+		s = string(name)
+	default:
+		return nil, false
+	}
+	factory, ok := d.types[s]
+	return factory, ok
+}
+
+// unmarshalRegistered decodes the dictionary rawData into a new value from
+// factory and stores it in the interface v.
+func (d *Decoder) unmarshalRegistered(rawData any, v reflect.Value, factory func() any) error {
+	obj := factory()
+	target := reflect.ValueOf(obj)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return fmt.Errorf("bencode: factory for registered type must return a non-nil pointer, got %T", obj)
+	}
+	if !target.Type().AssignableTo(v.Type()) {
+		return d.typeError(rawData, v.Type())
+	}
+	if err := d.unmarshal(rawData, target); err != nil {
+		return err
+	}
+	v.Set(target)
+	return nil
+}
+
+// unmarshalExtra populates the field f of the struct v, which has the "extra"
+// tag option, with the entries of rawMap whose keys are not in matched.
+func (d *Decoder) unmarshalExtra(rawMap map[string]any, matched map[string]bool, v reflect.Value, f field) error {
+	fv := fieldByIndexAlloc(v, f.index)
+	if fv.Kind() != reflect.Map || fv.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("bencode: extra field %s.%s must be a map with string keys", v.Type(), f.key)
+	}
+
+	unmatched := make(map[string]any, len(rawMap)-len(matched))
+	for key, rawValue := range rawMap {
+		if !matched[key] {
+			unmatched[key] = rawValue
+		}
+	}
+	if len(unmatched) == 0 {
+		return nil
+	}
+	return d.unmarshal(unmatched, fv)
+}
+
+// unmarshalField populates f, a field of the struct v, with rawData.
+//
+// If the value does not fit the field's type, meaning unmarshaling it returns
+// an *UnmarshalTypeError, and the field has a "variant" or
+// "fallback" tag option, the field is left at its zero value and the value is
+// routed to the named sibling field instead. A variant field receives the value
+// when it fits the variant's type, and a fallback field of type RawMessage
+// captures anything that fits neither.
+func (d *Decoder) unmarshalField(rawData any, v, f reflect.Value, opts tagOptions) error {
+	raw := d.raw
+	var err error
+	if opts.Contains("string") && quotable(f.Type()) {
+		err = d.unmarshalQuoted(rawData, f)
+	} else {
+		err = d.unmarshal(rawData, f)
+	}
+	if err == nil {
+		if opts.Contains("pow2") {
+			return d.checkPow2(f)
+		}
+		return nil
+	}
+
+	variant, hasVariant := opts.Get("variant")
+	fallback, hasFallback := opts.Get("fallback")
+	if !hasVariant && !hasFallback {
+		return err
+	}
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	f.Set(reflect.Zero(f.Type()))
+	d.raw = raw
+
+	if hasVariant {
+		vf, err := siblingField(v, variant, "variant")
+		if err != nil {
+			return err
+		}
+		if d.unmarshal(rawData, vf) == nil {
+			return nil
+		}
+		vf.Set(reflect.Zero(vf.Type()))
+		d.raw = raw
+	}
+
+	if hasFallback {
+		ff, err := siblingField(v, fallback, "fallback")
+		if err != nil {
+			return err
+		}
+		if ff.Type() != rawMessageType {
+			return fmt.Errorf("bencode: fallback field %s.%s must be of type RawMessage", v.Type(), fallback)
+		}
+		return d.unmarshal(rawData, ff)
+	}
+
+	return err
+}
+
+// unmarshalQuoted populates f, a field with the "string" option, from a
+// string holding an integer or a boolean in the form Marshal writes it, such
+// as "42" or "true". The integer is then stored as if it had been a bencode
+// integer, so the field's range is checked as usual.
+func (d *Decoder) unmarshalQuoted(rawData any, f reflect.Value) error {
+	var s string
+	switch raw := rawData.(type) {
+	case string:
+		s = raw
+	case []byte:
+		s = string(raw)
+	default:
+		return d.typeError(rawData, f.Type())
+	}
+	invalid := d.valueError("string "+strconv.Quote(s), f.Type())
+
+	t := f.Type()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Bool {
+		if s != "true" && s != "false" {
+			return invalid
+		}
+		if f.Kind() == reflect.Pointer {
+			if f.IsNil() {
+				f.Set(reflect.New(t))
+			}
+			f = f.Elem()
+		}
+		f.SetBool(s == "true")
+		return nil
+	}
+
+	if d.r.maxIntDigits > 0 && len(s) > d.r.maxIntDigits+1 {
+		return invalid
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.String() != s {
+		return invalid
+	}
+	if n.IsInt64() {
+		return d.unmarshal(n.Int64(), f)
+	}
+	return d.unmarshal(n, f)
+}
+
+// checkPow2 enforces the "pow2" tag option, which requires an integer field,
+// such as a torrent's piece length, to hold a positive power of two.
+func (d *Decoder) checkPow2(f reflect.Value) error {
+	for f.Kind() == reflect.Pointer {
+		f = f.Elem()
+	}
+
+	var n uint64
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f.Int() <= 0 {
+			return fmt.Errorf("bencode: value %d at key %q is not a positive power of two", f.Int(), strings.Join(d.path, "."))
+		}
+		n = uint64(f.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = f.Uint()
+	default:
+		return fmt.Errorf("bencode: pow2 option requires an integer field, got %s", f.Type())
+	}
+
+	if n == 0 || n&(n-1) != 0 {
+		return fmt.Errorf("bencode: value %d at key %q is not a positive power of two", n, strings.Join(d.path, "."))
+	}
+	return nil
+}
+
+// siblingField returns the settable field named name of the struct v, which
+// is referenced by the given tag option of another field.
+func siblingField(v reflect.Value, name, option string) (reflect.Value, error) {
+	f := v.FieldByName(name)
+	if !f.IsValid() || !f.CanSet() {
+		return reflect.Value{}, fmt.Errorf("bencode: %s field %s.%s is not an exported field", option, v.Type(), name)
+	}
+	return f, nil
+}
+
+// typeError returns an *UnmarshalTypeError for rawData, which cannot be stored
+// in a Go value of type t, at the current field path.
+func (d *Decoder) typeError(rawData any, t reflect.Type) error {
+	return d.valueError(describe(rawData), t)
+}
+
+// rangeError returns an *UnmarshalTypeError for the integer with the given
+// decimal digits, which is out of range for a Go value of type t, at the
+// current field path.
+func (d *Decoder) rangeError(digits string, t reflect.Type) error {
+	return d.valueError("integer "+digits, t)
+}
+
+// valueError returns an *UnmarshalTypeError for the value being unmarshaled,
+// described by value, which does not fit a Go value of type t.
+func (d *Decoder) valueError(value string, t reflect.Type) error {
+	return &UnmarshalTypeError{Value: value, Type: t, Field: strings.Join(d.path, "."), Offset: d.valueOffset()}
+}
+
+// describe returns the name of the kind of bencode value that rawData holds.
+func describe(rawData any) string {
+	switch rawData.(type) {
+	case string, []byte:
+		return "string"
+	case int, int64, *big.Int, Number:
+		return "integer"
+	case []any:
+		return "list"
+	case map[string]any, *OrderedDict:
+		return "dictionary"
+	default:
+		return fmt.Sprintf("%T", rawData)
+	}
+}
@@ -1,8 +1,18 @@
 package bencode
 
 import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 type unmarshalTest struct {
@@ -105,6 +115,30 @@ var unmarshalTests = []unmarshalTest{
 		out:     &struct{}{},
 		wantErr: true,
 	},
+	{
+		name: "Dict to Empty Struct",
+		in:   "d3:barl1:ae3:fooi1ee",
+		out:  &struct{}{},
+		want: &struct{}{},
+	},
+	{
+		name: "Empty Dict to Empty Struct",
+		in:   "de",
+		out:  &struct{}{},
+		want: &struct{}{},
+	},
+	{
+		name: "Map of Empty Structs",
+		in:   "d1:ade1:bd1:xi1eee",
+		out:  new(map[string]struct{}),
+		want: &map[string]struct{}{"a": {}, "b": {}},
+	},
+	{
+		name:    "Type Mismatch Integer to Empty Struct",
+		in:      "i1e",
+		out:     &struct{}{},
+		wantErr: true,
+	},
 	{
 		name:    "Type Mismatch Dict to Slice",
 		in:      "de",
@@ -143,6 +177,47 @@ var unmarshalTests = []unmarshalTest{
 			Foo: "bar",
 		},
 	},
+	{
+		name: "RawMessage",
+		in:   "d4:infod4:name3:fooee",
+		out:  new(map[string]RawMessage),
+		want: &map[string]RawMessage{"info": RawMessage("d4:name3:fooe")},
+	},
+	{
+		name: "Interface Slice",
+		in:   "l4:spami42eli1eed3:foo3:baree",
+		out:  new([]any),
+		want: &[]any{"spam", int64(42), []any{int64(1)}, map[string]any{"foo": "bar"}},
+	},
+	{
+		name: "Map Slice",
+		in:   "ld4:name1:a4:sizei1eed4:name1:b5:filesl1:xeee",
+		out:  new([]map[string]any),
+		want: &[]map[string]any{
+			{"name": "a", "size": int64(1)},
+			{"name": "b", "files": []any{"x"}},
+		},
+	},
+	{
+		name:    "Non-Empty Interface Slice",
+		in:      "l4:spame",
+		out:     new([]fmt.Stringer),
+		wantErr: true,
+	},
+	{
+		name: "Ignored Field",
+		in:   "d3:foo3:bar3:baz3:quxe",
+		out: &struct {
+			Foo string `bencode:"foo"`
+			Baz string `bencode:"-"`
+		}{},
+		want: &struct {
+			Foo string `bencode:"foo"`
+			Baz string `bencode:"-"`
+		}{
+			Foo: "bar",
+		},
+	},
 }
 
 func TestUnmarshal(t *testing.T) {
@@ -154,6 +229,11 @@ func TestUnmarshal(t *testing.T) {
 				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
 			}
 
+			var typeErr *UnmarshalTypeError
+			if tc.wantErr && !errors.As(err, &typeErr) {
+				t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+			}
+
 			if !tc.wantErr {
 				// Dereference the pointer to get the actual value
 				val := reflect.ValueOf(tc.out).Elem().Interface()
@@ -179,3 +259,1731 @@ func TestUnmarshalInvalidInput(t *testing.T) {
 		t.Error("expected an error for non-pointer")
 	}
 }
+
+func TestUnmarshalFallback(t *testing.T) {
+	type Info struct {
+		Count    int        `bencode:"count,fallback=CountRaw"`
+		CountRaw RawMessage `bencode:"-"`
+		Name     string     `bencode:"name"`
+	}
+
+	var got Info
+	err := Unmarshal([]byte("d5:countl1:ae4:name3:fooe"), &got)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	want := Info{CountRaw: RawMessage("l1:ae"), Name: "foo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	var bad struct {
+		Count int `bencode:"count,fallback=Missing"`
+	}
+	if err := Unmarshal([]byte("d5:count3:fooe"), &bad); err == nil {
+		t.Error("expected an error for a missing fallback field")
+	}
+}
+
+func TestDecoderRawMessageCapture(t *testing.T) {
+	// A stream decoded into a RawMessage is captured as it is read, and a
+	// large capture is not held on to once the value is unmarshaled.
+	big := strings.Repeat("x", 4*maxRetainedCapture)
+	d := NewDecoder(strings.NewReader(strconv.Itoa(len(big)) + ":" + big + "i1e"))
+	var raw RawMessage
+	if err := d.Decode(&raw); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if want := strconv.Itoa(len(big)) + ":" + big; string(raw) != want {
+		t.Errorf("Decode() got a RawMessage of %d bytes, want %d", len(raw), len(want))
+	}
+	if c := cap(d.r.capture); c > maxRetainedCapture {
+		t.Errorf("Decoder kept a capture buffer of %d bytes, want at most %d", c, maxRetainedCapture)
+	}
+
+	// Values of types that cannot hold a RawMessage are not captured.
+	var n int
+	if err := d.Decode(&n); err != nil || n != 1 {
+		t.Fatalf("Decode() = %d, %v, want 1, nil", n, err)
+	}
+	if len(d.r.capture) != 0 {
+		t.Errorf("Decode() into an int captured %d bytes", len(d.r.capture))
+	}
+
+	// A pooled decoder does not keep its input once released.
+	p := getDecoder([]byte("i1e"))
+	p.release()
+	if p.r.data != nil {
+		t.Error("release() kept a reference to the input")
+	}
+}
+
+func TestUnmarshalRawMessageVerbatim(t *testing.T) {
+	// A RawMessage keeps the bytes of its value as they were in the input,
+	// including keys out of order and integers that are not canonical.
+	const info = "d4:name1:a6:lengthi01ee"
+
+	var top RawMessage
+	if err := Unmarshal([]byte(info), &top); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if string(top) != info {
+		t.Errorf("Unmarshal() got = %q, want %q", top, info)
+	}
+
+	type torrent struct {
+		Announce string       `bencode:"announce"`
+		Info     RawMessage   `bencode:"info"`
+		Nodes    []RawMessage `bencode:"nodes"`
+		Extra    any          `bencode:"extra"`
+	}
+	in := "d4:info" + info + "5:nodesld1:bi1e1:ai2eei-0ee8:announce3:urle"
+	want := torrent{
+		Announce: "url",
+		Info:     RawMessage(info),
+		Nodes:    []RawMessage{RawMessage("d1:bi1e1:ai2ee"), RawMessage("i-0e")},
+	}
+
+	var got torrent
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	// The same holds when decoding from a stream, value after value.
+	d := NewDecoder(strings.NewReader(in + in))
+	for i := 0; i < 2; i++ {
+		got = torrent{}
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Decode() got = %#v, want %#v", got, want)
+		}
+	}
+
+	// A value built from several entries has no bytes of its own, so it is
+	// encoded instead.
+	d = NewDecoder(strings.NewReader("d1:ai2e1:ai1ee"))
+	d.SetDuplicateKeyPolicy(Collect)
+	var collected map[string]RawMessage
+	if err := d.Decode(&collected); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got := string(collected["a"]); got != "li2ei1ee" {
+		t.Errorf("Decode() with Collect got = %q, want %q", got, "li2ei1ee")
+	}
+}
+
+func TestUnmarshalBigInt(t *testing.T) {
+	const digits = "123456789012345678901234567890"
+	want, _ := new(big.Int).SetString(digits, 10)
+
+	var generic any
+	if err := Unmarshal([]byte("i"+digits+"e"), &generic); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if b, ok := generic.(*big.Int); !ok || b.Cmp(want) != 0 {
+		t.Errorf("Unmarshal() got = %#v, want *big.Int %s", generic, digits)
+	}
+
+	var field struct {
+		Value *big.Int `bencode:"value"`
+		Small big.Int  `bencode:"small"`
+	}
+	if err := Unmarshal([]byte("d5:smalli-7e5:valuei-"+digits+"ee"), &field); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if field.Value == nil || field.Value.Cmp(new(big.Int).Neg(want)) != 0 {
+		t.Errorf("Unmarshal() Value = %v, want -%s", field.Value, digits)
+	}
+	if field.Small.Int64() != -7 {
+		t.Errorf("Unmarshal() Small = %v, want -7", &field.Small)
+	}
+
+	var i int64
+	if err := Unmarshal([]byte("i"+digits+"e"), &i); err == nil {
+		t.Error("expected an error unmarshaling a big integer into int64")
+	}
+}
+
+func TestDecoderTrimStringFields(t *testing.T) {
+	type Torrent struct {
+		Announce string   `bencode:"announce"`
+		Name     []byte   `bencode:"name"`
+		URLs     []string `bencode:"url-list"`
+	}
+
+	const in = "d8:announce25: http://tracker/announce\n4:name5: foo 8:url-listl7:\ta.com\t5:b.comee"
+
+	var trimmed Torrent
+	d := NewDecoder(strings.NewReader(in))
+	d.TrimStringFields()
+	if err := d.Decode(&trimmed); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := Torrent{Announce: "http://tracker/announce", Name: []byte(" foo "), URLs: []string{"a.com", "b.com"}}
+	if !reflect.DeepEqual(trimmed, want) {
+		t.Errorf("Decode() got = %#v, want %#v", trimmed, want)
+	}
+
+	var untrimmed Torrent
+	if err := Unmarshal([]byte(in), &untrimmed); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if untrimmed.Announce != " http://tracker/announce\n" {
+		t.Errorf("Unmarshal() trimmed without the option: %q", untrimmed.Announce)
+	}
+}
+
+func TestUnmarshalVariant(t *testing.T) {
+	type Stats struct {
+		CountInt *int64     `bencode:"count,variant=CountStr,fallback=CountRaw"`
+		CountStr *string    `bencode:"-"`
+		CountRaw RawMessage `bencode:"-"`
+	}
+
+	testCases := []struct {
+		name string
+		in   string
+		want Stats
+	}{
+		{name: "Integer", in: "d5:counti42ee", want: Stats{CountInt: ptr(int64(42))}},
+		{name: "String", in: "d5:count2:42e", want: Stats{CountStr: ptr("42")}},
+		{name: "Neither", in: "d5:countli42eee", want: Stats{CountRaw: RawMessage("li42ee")}},
+		{name: "Absent", in: "de", want: Stats{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Stats
+			if err := Unmarshal([]byte(tc.in), &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Unmarshal() got = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+
+	var noFallback struct {
+		CountInt int64 `bencode:"count,variant=CountStr"`
+		CountStr string
+	}
+	if err := Unmarshal([]byte("d5:countli42eee"), &noFallback); err == nil {
+		t.Error("expected an error when the value fits neither field")
+	}
+
+	var missing struct {
+		CountInt int64 `bencode:"count,variant=Missing"`
+	}
+	if err := Unmarshal([]byte("d5:count2:42e"), &missing); err == nil {
+		t.Error("expected an error for a missing variant field")
+	}
+}
+
+func TestDecoderCaseInsensitive(t *testing.T) {
+	type Info struct {
+		Name   string
+		Length int    `bencode:"length"`
+		Source string `bencode:"source"`
+	}
+
+	testCases := []struct {
+		name string
+		in   string
+		want Info
+	}{
+		{name: "Exact", in: "d6:lengthi1e4:Name3:fooe", want: Info{Name: "foo", Length: 1}},
+		{name: "Mismatched Field Name", in: "d4:name3:fooe", want: Info{Name: "foo"}},
+		{name: "Mismatched Tag", in: "d6:LENGTHi7e6:Source1:xe", want: Info{Length: 7, Source: "x"}},
+		{name: "Exact Preferred", in: "d6:LENGTHi7e6:lengthi9ee", want: Info{Length: 9}},
+		{name: "Smallest Fold Preferred", in: "d6:LENGTHi7e6:Lengthi9ee", want: Info{Length: 7}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.in))
+			d.CaseInsensitive()
+
+			var got Info
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Decode() got = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+
+	var got Info
+	if err := Unmarshal([]byte("d4:name3:fooe"), &got); err != nil || got.Name != "" {
+		t.Errorf("Unmarshal() matched case-insensitively by default: %#v, %v", got, err)
+	}
+}
+
+type Common struct {
+	Name    string `bencode:"name"`
+	Comment string `bencode:"comment"`
+}
+
+type embeddedInfo struct {
+	Common
+	Length  int    `bencode:"length"`
+	Comment string `bencode:"comment"` // Shadows Common.Comment
+}
+
+type embeddedPointerInfo struct {
+	*Common
+	Length int `bencode:"length"`
+}
+
+func TestUnmarshalEmbedded(t *testing.T) {
+	const in = "d7:comment5:outer6:lengthi3e4:name3:fooe"
+
+	var got embeddedInfo
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := embeddedInfo{Common: Common{Name: "foo"}, Length: 3, Comment: "outer"}
+	if got != want {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	var gotPtr embeddedPointerInfo
+	if err := Unmarshal([]byte(in), &gotPtr); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if gotPtr.Common == nil || *gotPtr.Common != (Common{Name: "foo", Comment: "outer"}) || gotPtr.Length != 3 {
+		t.Errorf("Unmarshal() got = %#v", gotPtr)
+	}
+
+	var absent embeddedPointerInfo
+	if err := Unmarshal([]byte("d6:lengthi3ee"), &absent); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if absent.Common != nil {
+		t.Errorf("Unmarshal() allocated embedded pointer without any of its keys: %#v", absent.Common)
+	}
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	type Info struct {
+		Length int              `bencode:"length"`
+		Files  map[string]uint8 `bencode:"files"`
+	}
+
+	testCases := []struct {
+		name    string
+		in      string
+		out     any
+		want    UnmarshalTypeError
+		wantMsg string
+	}{
+		{
+			name:    "String to Int",
+			in:      "4:spam",
+			out:     new(int),
+			want:    UnmarshalTypeError{Value: "string", Type: reflect.TypeFor[int]()},
+			wantMsg: "bencode: cannot unmarshal string into Go value of type int",
+		},
+		{
+			name: "List to Struct",
+			in:   "li1ee",
+			out:  new(Info),
+			want: UnmarshalTypeError{Value: "list", Type: reflect.TypeFor[Info]()},
+		},
+		{
+			name: "Nested Field",
+			in:   "d4:infod6:length3:fooee",
+			out: new(struct {
+				Info Info `bencode:"info"`
+			}),
+			want:    UnmarshalTypeError{Value: "string", Type: reflect.TypeFor[int](), Field: "info.length", Offset: 16},
+			wantMsg: `bencode: cannot unmarshal string into Go value of type int at key "info.length"`,
+		},
+		{
+			name: "Overflow in Map",
+			in:   "d5:filesd1:ai256eee",
+			out:  new(Info),
+			want: UnmarshalTypeError{Value: "integer 256", Type: reflect.TypeFor[uint8](), Field: "files.a", Offset: 12},
+		},
+		{
+			name: "Negative to Unsigned",
+			in:   "i-1e",
+			out:  new(uint),
+			want: UnmarshalTypeError{Value: "integer -1", Type: reflect.TypeFor[uint]()},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.in), tc.out)
+
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+			}
+			if *typeErr != tc.want {
+				t.Errorf("Unmarshal() error = %#v, want %#v", *typeErr, tc.want)
+			}
+			if tc.wantMsg != "" && err.Error() != tc.wantMsg {
+				t.Errorf("Error() = %q, want %q", err.Error(), tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestUnmarshalTypeErrorOffset(t *testing.T) {
+	type File struct {
+		Length int `bencode:"length"`
+	}
+
+	testCases := []struct {
+		name string
+		in   string
+		out  any
+		want int64
+	}{
+		{name: "List Element", in: "li1ei2e3:fooe", out: new([]int), want: 7},
+		{name: "Unsorted Keys", in: "d1:b3:foo1:ai1ee", out: new(map[string]int), want: 4},
+		{name: "Struct In List", in: "ld6:lengthi1eed6:length1:xee", out: new([]File), want: 23},
+		{name: "Array Length", in: "d1:ali1eee", out: new(map[string][2]int), want: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.in), tc.out)
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+			}
+			if typeErr.Offset != tc.want {
+				t.Errorf("Unmarshal() error offset = %d, want %d", typeErr.Offset, tc.want)
+			}
+		})
+	}
+
+	// A Decoder reading from a stream reports offsets from the start of the
+	// stream rather than of the value, where it keeps the input to find them.
+	type Holder struct {
+		Count int        `bencode:"count"`
+		Raw   RawMessage `bencode:"raw"`
+	}
+	d := NewDecoder(iotest.OneByteReader(strings.NewReader("i1ed5:count3:foo3:rawi1eed5:count3:fooe")))
+	var first int
+	if err := d.Decode(&first); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	var typeErr *UnmarshalTypeError
+	var holder Holder
+	if err := d.Decode(&holder); !errors.As(err, &typeErr) || typeErr.Offset != 11 {
+		t.Errorf("Decode() into a RawMessage holder error = %v, want an *UnmarshalTypeError at offset 11", err)
+	}
+
+	// Otherwise the stream is not kept, and the offset is not known.
+	var counts map[string]int
+	if err := d.Decode(&counts); !errors.As(err, &typeErr) || typeErr.Offset != -1 {
+		t.Errorf("Decode() into a map error = %v, want an *UnmarshalTypeError at offset -1", err)
+	}
+}
+
+func TestUnmarshalDecodedAt(t *testing.T) {
+	type Record struct {
+		Name      string    `bencode:"name"`
+		DecodedAt time.Time `bencode:",decodedAt"`
+		Created   time.Time `bencode:"created"`
+	}
+
+	before := time.Now()
+	var got Record
+	if err := Unmarshal([]byte("d7:createdi0e9:DecodedAti1e4:name3:fooe"), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	after := time.Now()
+
+	if got.DecodedAt.Before(before) || got.DecodedAt.After(after) {
+		t.Errorf("DecodedAt = %v, want between %v and %v", got.DecodedAt, before, after)
+	}
+	if !got.Created.Equal(time.Unix(0, 0)) || got.Name != "foo" {
+		t.Errorf("Unmarshal() got = %#v", got)
+	}
+
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if want := "d7:createdi0e4:name3:fooe"; string(out) != want {
+		t.Errorf("Marshal() got = %q, want %q", out, want)
+	}
+
+	var bad struct {
+		DecodedAt int64 `bencode:",decodedAt"`
+	}
+	if err := Unmarshal([]byte("de"), &bad); err == nil {
+		t.Error("expected an error for a decodedAt field that is not a time.Time")
+	}
+}
+
+func TestInvalidUnmarshalError(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{name: "Nil", v: nil, want: "bencode: Unmarshal(nil)"},
+		{name: "Non-Pointer", v: "", want: "bencode: Unmarshal(non-pointer string)"},
+		{name: "Nil Pointer", v: (*int)(nil), want: "bencode: Unmarshal(nil *int)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Unmarshal([]byte("i42e"), tc.v)
+
+			var invalidErr *InvalidUnmarshalError
+			if !errors.As(err, &invalidErr) {
+				t.Fatalf("Unmarshal() error = %v, want *InvalidUnmarshalError", err)
+			}
+			if invalidErr.Type != reflect.TypeOf(tc.v) {
+				t.Errorf("Type = %v, want %v", invalidErr.Type, reflect.TypeOf(tc.v))
+			}
+			if err.Error() != tc.want {
+				t.Errorf("Error() = %q, want %q", err.Error(), tc.want)
+			}
+		})
+	}
+}
+
+// version is decoded from and encoded as text such as "1.2".
+type version struct {
+	Major, Minor int
+}
+
+func (v *version) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d.%d", &v.Major, &v.Minor)
+	return err
+}
+
+func (v version) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d.%d", v.Major, v.Minor)), nil
+}
+
+func TestUnmarshalText(t *testing.T) {
+	type Peer struct {
+		IP      net.IP   `bencode:"ip"`
+		Version *version `bencode:"v"`
+	}
+
+	const in = "d2:ip10:192.0.2.101:v3:1.2e"
+	want := Peer{IP: net.ParseIP("192.0.2.10"), Version: &version{Major: 1, Minor: 2}}
+
+	var got Peer
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !got.IP.Equal(want.IP) || *got.Version != *want.Version {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	out, err := Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("Marshal() got = %q, want %q", out, in)
+	}
+
+	if err := Unmarshal([]byte("d1:v1:xe"), &got); err == nil {
+		t.Error("expected an error from UnmarshalText")
+	}
+}
+
+func TestUnmarshalPow2(t *testing.T) {
+	type Info struct {
+		PieceLength int64 `bencode:"piece length,pow2"`
+	}
+
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "16384", in: "d12:piece lengthi16384ee"},
+		{name: "One", in: "d12:piece lengthi1ee"},
+		{name: "1000", in: "d12:piece lengthi1000ee", wantErr: true},
+		{name: "Zero", in: "d12:piece lengthi0ee", wantErr: true},
+		{name: "Negative", in: "d12:piece lengthi-16384ee", wantErr: true},
+		{name: "Absent", in: "de"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Info
+			err := Unmarshal([]byte(tc.in), &got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	var unsigned struct {
+		PieceLength *uint32 `bencode:"piece length,pow2"`
+	}
+	if err := Unmarshal([]byte("d12:piece lengthi262144ee"), &unsigned); err != nil || *unsigned.PieceLength != 262144 {
+		t.Errorf("Unmarshal() got %v, error = %v", unsigned.PieceLength, err)
+	}
+}
+
+func TestUnmarshalExtra(t *testing.T) {
+	type Torrent struct {
+		Announce string         `bencode:"announce"`
+		Comment  string         `bencode:"comment"`
+		Extra    map[string]any `bencode:",extra"`
+	}
+
+	const in = "d8:announce3:url7:comment2:hi10:created by4:tool13:creation datei1700000000e4:infod4:name3:fooee"
+
+	var got Torrent
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Torrent{
+		Announce: "url",
+		Comment:  "hi",
+		Extra: map[string]any{
+			"created by":    "tool",
+			"creation date": int64(1700000000),
+			"info":          map[string]any{"name": "foo"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+
+	// The extra keys are written back alongside the known ones.
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("Marshal() got = %q, want %q", out, in)
+	}
+
+	// With every key matched, the map is left nil.
+	var known Torrent
+	if err := Unmarshal([]byte("d8:announce3:urle"), &known); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if known.Extra != nil {
+		t.Errorf("Extra = %#v, want nil", known.Extra)
+	}
+
+	// Keys matched case-insensitively are not treated as extra.
+	d := NewDecoder(strings.NewReader("d8:ANNOUNCE3:url5:otheri1ee"))
+	d.CaseInsensitive()
+	var folded Torrent
+	if err := d.Decode(&folded); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if folded.Announce != "url" || !reflect.DeepEqual(folded.Extra, map[string]any{"other": int64(1)}) {
+		t.Errorf("Decode() got = %#v", folded)
+	}
+
+	var bad struct {
+		Extra []any `bencode:",extra"`
+	}
+	if err := Unmarshal([]byte("d3:fooi1ee"), &bad); err == nil {
+		t.Error("expected an error for an extra field that is not a map")
+	}
+}
+
+func TestTagName(t *testing.T) {
+	type Peer struct {
+		IP     string `torrent:"ip" bencode:"address"`
+		Port   int    `torrent:"port,pow2"`
+		PeerID string `torrent:"peer id"`
+		Secret string `torrent:"-"`
+	}
+
+	d := NewDecoder(strings.NewReader("d2:ip9:127.0.0.17:peer id3:abc4:porti8e6:Secreti1ee"))
+	d.SetTagName("torrent")
+	var got Peer
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := Peer{IP: "127.0.0.1", Port: 8, PeerID: "abc"}
+	if got != want {
+		t.Errorf("Decode() got = %#v, want %#v", got, want)
+	}
+
+	var buf strings.Builder
+	e := NewEncoder(&buf)
+	e.SetTagName("torrent")
+	if err := e.Encode(got); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if want := "d2:ip9:127.0.0.17:peer id3:abc4:porti8ee"; buf.String() != want {
+		t.Errorf("Encode() got = %q, want %q", buf.String(), want)
+	}
+
+	// The default tag name is unaffected.
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if want := "d6:PeerID3:abc4:Porti8e6:Secret0:7:address9:127.0.0.1e"; string(out) != want {
+		t.Errorf("Marshal() got = %q, want %q", out, want)
+	}
+}
+
+func TestUint64RoundTrip(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "MaxInt64", in: "i9223372036854775807e", want: math.MaxInt64},
+		{name: "MaxInt64 Plus One", in: "i9223372036854775808e", want: math.MaxInt64 + 1},
+		{name: "MaxUint64", in: "i18446744073709551615e", want: math.MaxUint64},
+		{name: "MaxUint64 Plus One", in: "i18446744073709551616e", wantErr: true},
+		{name: "Negative", in: "i-1e", wantErr: true},
+		{name: "Huge Negative", in: "i-18446744073709551616e", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got uint64
+			err := Unmarshal([]byte(tc.in), &got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				var typeErr *UnmarshalTypeError
+				if !errors.As(err, &typeErr) {
+					t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+				}
+				return
+			}
+			if got != tc.want {
+				t.Errorf("Unmarshal() got = %d, want %d", got, tc.want)
+			}
+
+			out, err := Marshal(got)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(out) != tc.in {
+				t.Errorf("Marshal() got = %q, want %q", out, tc.in)
+			}
+		})
+	}
+
+	// Narrower unsigned types still reject values above MaxInt64.
+	var u32 uint32
+	if err := Unmarshal([]byte("i9223372036854775808e"), &u32); err == nil {
+		t.Error("Unmarshal() into uint32 of a value above MaxInt64 returned no error")
+	}
+}
+
+type message interface {
+	kind() string
+}
+
+type pingMessage struct {
+	Type string `bencode:"type"`
+	ID   int    `bencode:"id"`
+}
+
+func (*pingMessage) kind() string { return "ping" }
+
+type dataMessage struct {
+	Type    string `bencode:"type"`
+	Payload []byte `bencode:"payload"`
+}
+
+func (*dataMessage) kind() string { return "data" }
+
+func TestDecoderRegisterType(t *testing.T) {
+	const in = "ld2:idi1e4:type4:pinged7:payload3:abc4:type4:dataed2:idi2e4:type4:pingee"
+
+	newDecoder := func(in string) *Decoder {
+		d := NewDecoder(strings.NewReader(in))
+		d.SetTypeKey("type")
+		d.RegisterType("ping", func() any { return new(pingMessage) })
+		d.RegisterType("data", func() any { return new(dataMessage) })
+		return d
+	}
+
+	var got []message
+	if err := newDecoder(in).Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := []message{
+		&pingMessage{Type: "ping", ID: 1},
+		&dataMessage{Type: "data", Payload: []byte("abc")},
+		&pingMessage{Type: "ping", ID: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %#v, want %#v", got, want)
+	}
+
+	// In an empty interface, unregistered or untyped dictionaries stay generic.
+	var mixed []any
+	if err := newDecoder("ld4:type4:pinged4:type4:nopeedee").Decode(&mixed); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	wantMixed := []any{&pingMessage{Type: "ping"}, map[string]any{"type": "nope"}, map[string]any{}}
+	if !reflect.DeepEqual(mixed, wantMixed) {
+		t.Errorf("Decode() got = %#v, want %#v", mixed, wantMixed)
+	}
+
+	// Without a type key, registered types are not used.
+	d := NewDecoder(strings.NewReader("ld4:type4:pingee"))
+	d.RegisterType("ping", func() any { return new(pingMessage) })
+	if err := d.Decode(&got); err == nil {
+		t.Error("Decode() into []message without a type key returned no error")
+	}
+
+	// A type that does not implement the interface is rejected.
+	d = newDecoder("ld4:type4:pingee")
+	d.RegisterType("ping", func() any { return new(string) })
+	if err := d.Decode(&got); err == nil {
+		t.Error("Decode() of a type not implementing the interface returned no error")
+	}
+
+	d = newDecoder("ld4:type4:pingee")
+	d.RegisterType("ping", func() any { return nil })
+	if err := d.Decode(&got); err == nil {
+		t.Error("Decode() with a nil factory result returned no error")
+	}
+}
+
+func TestUnmarshalMapKeys(t *testing.T) {
+	var ints map[int]string
+	if err := Unmarshal([]byte("d2:-11:c2:101:be"), &ints); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := map[int]string{-1: "c", 10: "b"}; !reflect.DeepEqual(ints, want) {
+		t.Errorf("Unmarshal() got = %v, want %v", ints, want)
+	}
+
+	type name string
+	var named map[name]int
+	if err := Unmarshal([]byte("d1:ai1ee"), &named); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := map[name]int{"a": 1}; !reflect.DeepEqual(named, want) {
+		t.Errorf("Unmarshal() got = %v, want %v", named, want)
+	}
+
+	var versions map[version]int
+	if err := Unmarshal([]byte("d3:1.2i1ee"), &versions); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := map[version]int{{Major: 1, Minor: 2}: 1}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("Unmarshal() got = %v, want %v", versions, want)
+	}
+
+	// Keys that do not parse as the key type are an *UnmarshalTypeError.
+	var small map[uint8]int
+	err := Unmarshal([]byte("d3:256i1ee"), &small)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+	}
+
+	var floats map[float64]int
+	if err := Unmarshal([]byte("d3:1.5i1ee"), &floats); err == nil {
+		t.Error("Unmarshal() into map[float64]int returned no error")
+	}
+
+	// Integer keys must be in the form Marshal writes, so that two keys never
+	// decode to the same map entry.
+	for _, key := range []string{"01", "+1", "-0", " 1", "1_0", ""} {
+		data := fmt.Sprintf("d%d:%si1ee", len(key), key)
+		var ints map[int]int
+		if err := Unmarshal([]byte(data), &ints); !errors.As(err, &typeErr) {
+			t.Errorf("Unmarshal(%q) into map[int]int error = %v, want *UnmarshalTypeError", data, err)
+		}
+		var uints map[uint]int
+		if err := Unmarshal([]byte(data), &uints); !errors.As(err, &typeErr) {
+			t.Errorf("Unmarshal(%q) into map[uint]int error = %v, want *UnmarshalTypeError", data, err)
+		}
+	}
+}
+
+func TestUnmarshalNumericStringKeys(t *testing.T) {
+	// Keys are always strings, so numeric-looking keys, as in the file-index
+	// dictionaries some clients send, stay exactly as written.
+	data := []byte("d2:-13:neg1:05:first2:013:pad1:15:value2:105:tenth1:25:othere")
+	want := map[string]string{"0": "first", "1": "value", "2": "other", "01": "pad", "-1": "neg", "10": "tenth"}
+
+	var strs map[string]string
+	if err := Unmarshal(data, &strs); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(strs, want) {
+		t.Errorf("Unmarshal() got = %v, want %v", strs, want)
+	}
+
+	var generic map[string]any
+	if err := Unmarshal(data, &generic); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if generic["01"] != "pad" || generic["1"] != "value" || len(generic) != len(want) {
+		t.Errorf("Unmarshal() got = %v, want %v", generic, want)
+	}
+
+	// Numeric-looking keys are matched by struct tags as plain strings.
+	var files struct {
+		One    string `bencode:"1"`
+		Two    string `bencode:"2"`
+		Padded string `bencode:"01"`
+		Ten    string `bencode:"10"`
+	}
+	if err := Unmarshal(data, &files); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if files.One != "value" || files.Two != "other" || files.Padded != "pad" || files.Ten != "tenth" {
+		t.Errorf("Unmarshal() got = %+v", files)
+	}
+
+	// Encoding sorts the keys as strings, so the dictionary round-trips.
+	out, err := Marshal(strs)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != string(data) {
+		t.Errorf("Marshal() got = %q, want %q", out, data)
+	}
+}
+
+func TestUnmarshalIntWidth(t *testing.T) {
+	// Each integer type is checked against its own width, including int and
+	// uint, whose width depends on the platform.
+	testCases := []struct {
+		name    string
+		out     any
+		in      string
+		wantErr bool
+	}{
+		{name: "Int8 Max", out: new(int8), in: "i127e"},
+		{name: "Int8 Overflow", out: new(int8), in: "i128e", wantErr: true},
+		{name: "Int8 Min", out: new(int8), in: "i-128e"},
+		{name: "Int8 Underflow", out: new(int8), in: "i-129e", wantErr: true},
+		{name: "Int16 Overflow", out: new(int16), in: "i32768e", wantErr: true},
+		{name: "Int32 Max", out: new(int32), in: "i2147483647e"},
+		{name: "Int32 Overflow", out: new(int32), in: "i2147483648e", wantErr: true},
+		{name: "Int Max", out: new(int), in: "i" + strconv.Itoa(math.MaxInt) + "e"},
+		{name: "Int Min", out: new(int), in: "i" + strconv.Itoa(math.MinInt) + "e"},
+		{name: "Int Overflow", out: new(int), in: "i" + new(big.Int).Add(big.NewInt(math.MaxInt), big.NewInt(1)).String() + "e", wantErr: true},
+		{name: "Int64 Overflow", out: new(int64), in: "i9223372036854775808e", wantErr: true},
+		{name: "Uint8 Overflow", out: new(uint8), in: "i256e", wantErr: true},
+		{name: "Uint Max", out: new(uint), in: "i" + strconv.FormatUint(math.MaxUint, 10) + "e"},
+		{name: "Uint Overflow", out: new(uint), in: "i" + new(big.Int).Add(new(big.Int).SetUint64(math.MaxUint), big.NewInt(1)).String() + "e", wantErr: true},
+		{name: "Any", out: new(any), in: "i9223372036854775807e"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.in), tc.out)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				var typeErr *UnmarshalTypeError
+				if !errors.As(err, &typeErr) {
+					t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+				}
+				return
+			}
+			got, err := Marshal(tc.out)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(got) != tc.in {
+				t.Errorf("round trip got = %q, want %q", got, tc.in)
+			}
+		})
+	}
+}
+
+func TestUnmarshalOptionalPointers(t *testing.T) {
+	type Sub struct {
+		Name string `bencode:"name"`
+	}
+	type Optional struct {
+		Sub   *Sub            `bencode:"sub"`
+		Map   *map[string]int `bencode:"map"`
+		Slice *[]string       `bencode:"slice"`
+		Int   *int            `bencode:"int"`
+		Deep  **int           `bencode:"deep"`
+	}
+
+	var absent Optional
+	if err := Unmarshal([]byte("de"), &absent); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if absent != (Optional{}) {
+		t.Errorf("Unmarshal() of absent keys got = %#v, want all nil", absent)
+	}
+
+	var present Optional
+	const in = "d4:deepi7e3:inti0e3:mapd1:ai1ee5:slicele3:subd4:name3:fooee"
+	if err := Unmarshal([]byte(in), &present); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if present.Sub == nil || present.Sub.Name != "foo" {
+		t.Errorf("Sub = %#v, want &{foo}", present.Sub)
+	}
+	if present.Map == nil || !reflect.DeepEqual(*present.Map, map[string]int{"a": 1}) {
+		t.Errorf("Map = %v, want &map[a:1]", present.Map)
+	}
+	if present.Slice == nil || len(*present.Slice) != 0 {
+		t.Errorf("Slice = %v, want a pointer to an empty slice", present.Slice)
+	}
+	if present.Int == nil || *present.Int != 0 {
+		t.Errorf("Int = %v, want a pointer to 0", present.Int)
+	}
+	if present.Deep == nil || *present.Deep == nil || **present.Deep != 7 {
+		t.Errorf("Deep = %v, want a pointer to a pointer to 7", present.Deep)
+	}
+
+	// An existing pointer is decoded into rather than replaced.
+	sub := &Sub{Name: "old"}
+	existing := Optional{Sub: sub}
+	if err := Unmarshal([]byte("d3:subd4:name3:newee"), &existing); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if existing.Sub != sub || sub.Name != "new" {
+		t.Errorf("Sub = %p %#v, want the existing %p updated", existing.Sub, existing.Sub, sub)
+	}
+}
+
+func TestUnmarshalMixedListField(t *testing.T) {
+	type record struct {
+		Items []any `bencode:"items"`
+	}
+	testCases := []struct {
+		name string
+		in   string
+		out  record
+		want []any
+	}{
+		{name: "Mixed", in: "d5:itemsl4:spami42eee", want: []any{"spam", int64(42)}},
+		{name: "Nested", in: "d5:itemsli-1eli1eed1:k1:veee", want: []any{int64(-1), []any{int64(1)}, map[string]any{"k": "v"}}},
+		{name: "Empty", in: "d5:itemslee", want: []any{}},
+		{
+			// The elements of an existing slice are replaced rather than
+			// decoded into, so their types do not constrain the new ones.
+			name: "Replaces Existing",
+			in:   "d5:itemsl4:spami42eee",
+			out:  record{Items: []any{int64(1), "x"}},
+			want: []any{"spam", int64(42)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.out
+			if err := Unmarshal([]byte(tc.in), &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Items, tc.want) {
+				t.Errorf("Unmarshal() got = %#v, want %#v", got.Items, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalNestedInterfaceSlice(t *testing.T) {
+	// Heterogeneous data arrays nested inside a struct, as some torrents
+	// carry, decode alongside typed fields at every level.
+	type info struct {
+		Name string `bencode:"name"`
+		Data []any  `bencode:"data"`
+		Meta any    `bencode:"meta"`
+	}
+	type torrent struct {
+		Info info  `bencode:"info"`
+		Data []any `bencode:"data"`
+	}
+	in := "d4:datali1el1:ad1:bi2eeee4:infod4:datal3:abcli-1eee4:metal1:xi3ee4:name4:spamee"
+
+	var got torrent
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := torrent{
+		Info: info{
+			Name: "spam",
+			Data: []any{"abc", []any{int64(-1)}},
+			Meta: []any{"x", int64(3)},
+		},
+		Data: []any{int64(1), []any{"a", map[string]any{"b": int64(2)}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+}
+
+func TestUnmarshalMapOfInterfaceSlices(t *testing.T) {
+	// Composite map values, as in scrape responses keyed by info hash, are
+	// decoded through the same recursion as any other value.
+	testCases := []struct {
+		name string
+		in   string
+		got  any
+		want any
+	}{
+		{
+			name: "slice of any",
+			in:   "d4:listli1ei2ee5:mixedl1:ali3eeee",
+			got:  new(map[string][]any),
+			want: &map[string][]any{"list": {int64(1), int64(2)}, "mixed": {"a", []any{int64(3)}}},
+		},
+		{
+			name: "nested map",
+			in:   "d5:filesd8:completeli5ei0eeee",
+			got:  new(map[string]map[string][]any),
+			want: &map[string]map[string][]any{"files": {"complete": {int64(5), int64(0)}}},
+		},
+		{
+			name: "slice of maps",
+			in:   "d5:peersld2:ip9:127.0.0.14:porti6881eeee",
+			got:  new(map[string][]map[string]any),
+			want: &map[string][]map[string]any{"peers": {{"ip": "127.0.0.1", "port": int64(6881)}}},
+		},
+		{
+			name: "empty list",
+			in:   "d4:listlee",
+			got:  new(map[string][]any),
+			want: &map[string][]any{"list": {}},
+		},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unmarshal([]byte(tt.in), tt.got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("Unmarshal() got = %#v, want %#v", tt.got, tt.want)
+			}
+		})
+	}
+
+	// A value that is not a list is an *UnmarshalTypeError at its key.
+	var m map[string][]any
+	err := Unmarshal([]byte("d4:listi1ee"), &m)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+	}
+}
+
+func TestUnmarshalRawMessageMapField(t *testing.T) {
+	// A torrent editor changes the fields it knows and passes the rest of the
+	// info dictionary through untouched.
+	type editor struct {
+		Announce string                `bencode:"announce"`
+		Info     map[string]RawMessage `bencode:"info"`
+		Extra    map[string]any        `bencode:",extra"`
+	}
+
+	var got editor
+	if err := Unmarshal([]byte(multiTrackerTorrent), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	wantInfo := map[string]RawMessage{
+		"files":        RawMessage("ld6:lengthi1024e4:pathl3:dir5:a.txteed6:lengthi2048e4:pathl5:b.txteee"),
+		"name":         RawMessage("7:example"),
+		"piece length": RawMessage("i16384e"),
+		"pieces":       RawMessage("20:01234567890123456789"),
+	}
+	if !reflect.DeepEqual(got.Info, wantInfo) {
+		t.Errorf("Unmarshal() Info = %q, want %q", got.Info, wantInfo)
+	}
+
+	got.Announce = "http://new.example.com/announce"
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := strings.Replace(multiTrackerTorrent,
+		"8:announce35:http://tracker.example.com/announce",
+		"8:announce31:http://new.example.com/announce", 1)
+	if string(out) != want {
+		t.Errorf("Marshal() got = %q, want %q", out, want)
+	}
+
+	// Sub-values are kept byte for byte, even where their keys are out of
+	// order, so that what the editor passes through is unchanged.
+	const files = "ld4:pathl5:b.txte6:lengthi2048eee"
+	const meta = "d4:infod5:files" + files + "4:name1:xe8:announce3:urle"
+	got = editor{}
+	if err := Unmarshal([]byte(meta), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if string(got.Info["files"]) != files {
+		t.Errorf("Unmarshal() Info[files] = %q, want %q", got.Info["files"], files)
+	}
+}
+
+func TestUnmarshalTypedMap(t *testing.T) {
+	t.Run("Int Values", func(t *testing.T) {
+		var got map[string]int
+		if err := Unmarshal([]byte("d1:ai1e1:bi-2ee"), &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if want := map[string]int{"a": 1, "b": -2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal() got = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("String Slice Values", func(t *testing.T) {
+		var got map[string][]string
+		if err := Unmarshal([]byte("d5:fruitl5:apple4:peare4:nonelee"), &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if want := map[string][]string{"fruit": {"apple", "pear"}, "none": {}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal() got = %v, want %v", got, want)
+		}
+	})
+
+	errorCases := []struct {
+		name string
+		in   string
+		out  any
+		want UnmarshalTypeError
+	}{
+		{
+			name: "String Into Int",
+			in:   "d1:ai1e5:count3:twoe",
+			out:  new(map[string]int),
+			want: UnmarshalTypeError{Value: "string", Type: reflect.TypeOf(0), Field: "count", Offset: 14},
+		},
+		{
+			name: "Integer Into String Slice",
+			in:   "d5:fruitl5:applei3eee",
+			out:  new(map[string][]string),
+			want: UnmarshalTypeError{Value: "integer", Type: reflect.TypeOf(""), Field: "fruit", Offset: 16},
+		},
+		{
+			name: "Nested",
+			in:   "d5:outerd5:innerleee",
+			out:  new(map[string]map[string]int),
+			want: UnmarshalTypeError{Value: "list", Type: reflect.TypeOf(0), Field: "outer.inner", Offset: 16},
+		},
+	}
+
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.in), tc.out)
+			var typeErr *UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+			}
+			if *typeErr != tc.want {
+				t.Errorf("Unmarshal() error = %+v, want %+v", *typeErr, tc.want)
+			}
+			if !strings.Contains(err.Error(), strconv.Quote(tc.want.Field)) {
+				t.Errorf("Unmarshal() error = %q, want it to name key %q", err, tc.want.Field)
+			}
+		})
+	}
+}
+
+func TestUnmarshalRequired(t *testing.T) {
+	type info struct {
+		Name        string `bencode:"name"`
+		PieceLength int64  `bencode:"piece length,required"`
+	}
+	type metainfo struct {
+		Announce string `bencode:"announce"`
+		Info     *info  `bencode:"info,required"`
+	}
+
+	testCases := []struct {
+		name    string
+		in      string
+		want    metainfo
+		wantErr string
+	}{
+		{
+			name: "Present",
+			in:   "d8:announce1:a4:infod4:name1:n12:piece lengthi16384eee",
+			want: metainfo{Announce: "a", Info: &info{Name: "n", PieceLength: 16384}},
+		},
+		{
+			name: "Optional Missing",
+			in:   "d4:infod12:piece lengthi16384eee",
+			want: metainfo{Info: &info{PieceLength: 16384}},
+		},
+		{
+			name: "Zero Value Present",
+			in:   "d4:infod12:piece lengthi0eee",
+			want: metainfo{Info: &info{}},
+		},
+		{name: "Missing", in: "d8:announce1:ae", wantErr: `bencode: missing required key "info"`},
+		{name: "Nested Missing", in: "d4:infod4:name1:nee", wantErr: `bencode: missing required key "info.piece length"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got metainfo
+			err := Unmarshal([]byte(tc.in), &got)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("Unmarshal() error = %v, want %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Unmarshal() got = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalInterfaceHoldingPointer(t *testing.T) {
+	t.Run("Int", func(t *testing.T) {
+		n := new(int)
+		var i any = n
+		if err := Unmarshal([]byte("i42e"), &i); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if i != any(n) || *n != 42 {
+			t.Errorf("Unmarshal() got i = %v, *n = %d, want the same pointer to 42", i, *n)
+		}
+	})
+
+	t.Run("Struct", func(t *testing.T) {
+		type file struct {
+			Name string `bencode:"name"`
+			Size int    `bencode:"size"`
+		}
+		f := &file{Name: "old", Size: 1}
+		var i any = f
+		if err := Unmarshal([]byte("d4:name3:newe"), &i); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if want := (file{Name: "new", Size: 1}); *f != want {
+			t.Errorf("Unmarshal() got = %+v, want %+v", *f, want)
+		}
+	})
+
+	t.Run("Field", func(t *testing.T) {
+		var s string
+		v := struct {
+			Value any `bencode:"value"`
+		}{Value: &s}
+		if err := Unmarshal([]byte("d5:value4:spame"), &v); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if s != "spam" {
+			t.Errorf("Unmarshal() got = %q, want %q", s, "spam")
+		}
+	})
+
+	t.Run("Pointer To Pointer", func(t *testing.T) {
+		var n *int64
+		var i any = &n
+		if err := Unmarshal([]byte("i-7e"), &i); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if n == nil || *n != -7 {
+			t.Errorf("Unmarshal() got = %v, want a pointer to -7", n)
+		}
+	})
+
+	t.Run("Incompatible", func(t *testing.T) {
+		var i any = new(int)
+		var typeErr *UnmarshalTypeError
+		if err := Unmarshal([]byte("4:spam"), &i); !errors.As(err, &typeErr) {
+			t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+		}
+	})
+
+	t.Run("Nil Pointer Not Followed", func(t *testing.T) {
+		var i any = (*int)(nil)
+		if err := Unmarshal([]byte("4:spam"), &i); err == nil {
+			t.Error("Unmarshal() into an interface holding a nil *int returned no error")
+		}
+	})
+
+	t.Run("Self Reference", func(t *testing.T) {
+		var i any
+		i = &i
+		if err := Unmarshal([]byte("i1e"), &i); err == nil {
+			t.Error("Unmarshal() into an interface holding a pointer to itself returned no error")
+		}
+	})
+}
+
+// trackerState is an enum-like named string type.
+type trackerState string
+
+const (
+	stateStarted   trackerState = "started"
+	stateCompleted trackerState = "completed"
+)
+
+// priority is a named integer type.
+type priority int8
+
+func TestUnmarshalNamedTypes(t *testing.T) {
+	type announce struct {
+		Event    trackerState              `bencode:"event"`
+		Priority priority                  `bencode:"priority"`
+		Port     uint16Named               `bencode:"port"`
+		States   []trackerState            `bencode:"states"`
+		Counts   map[trackerState]priority `bencode:"counts"`
+		Hash     hashBytes                 `bencode:"hash"`
+	}
+
+	in := "d6:countsd7:startedi1ee5:event9:completed4:hash2:\x01\x024:porti6881e8:priorityi-2e6:statesl7:startedee"
+	var got announce
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := announce{
+		Event:    stateCompleted,
+		Priority: -2,
+		Port:     6881,
+		States:   []trackerState{stateStarted},
+		Counts:   map[trackerState]priority{stateStarted: 1},
+		Hash:     hashBytes{1, 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %+v, want %+v", got, want)
+	}
+
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("Marshal() got = %q, want %q", out, in)
+	}
+
+	// The width of the underlying type still applies.
+	var p priority
+	var typeErr *UnmarshalTypeError
+	if err := Unmarshal([]byte("i300e"), &p); !errors.As(err, &typeErr) || typeErr.Type != reflect.TypeOf(p) {
+		t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError for %T", err, p)
+	}
+	var s trackerState
+	if err := Unmarshal([]byte("i1e"), &s); !errors.As(err, &typeErr) || typeErr.Type != reflect.TypeOf(s) {
+		t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError for %T", err, s)
+	}
+}
+
+// uint16Named is a named unsigned integer type.
+type uint16Named uint16
+
+// hashBytes is a named byte slice type.
+type hashBytes []byte
+
+func TestUnmarshalExtendedKeys(t *testing.T) {
+	// Keys from extension BEPs contain dashes, spaces and dots, all of which
+	// can be used as tag names as they are.
+	type extended struct {
+		URLList      []string `bencode:"url-list"`
+		HTTPSeeds    []string `bencode:"httpseeds"`
+		CreationDate int64    `bencode:"creation date"`
+		Dotted       string   `bencode:"x.pe"`
+		Padded       string   `bencode:" padded "`
+	}
+
+	in := "d8: padded 1:p13:creation datei1700000000e9:httpseedsl16:http://seed.teste8:url-listl10:http://a/a10:http://b/be4:x.pe4:1.2.e"
+	var got extended
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := extended{
+		URLList:      []string{"http://a/a", "http://b/b"},
+		HTTPSeeds:    []string{"http://seed.test"},
+		CreationDate: 1700000000,
+		Dotted:       "1.2.",
+		Padded:       "p",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %+v, want %+v", got, want)
+	}
+
+	out, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("Marshal() got = %q, want %q", out, in)
+	}
+
+	// The path in an error names such keys in full.
+	var typeErr *UnmarshalTypeError
+	err = Unmarshal([]byte("d13:creation date3:nowe"), &got)
+	if !errors.As(err, &typeErr) || typeErr.Field != "creation date" {
+		t.Errorf("Unmarshal() error = %v, want an *UnmarshalTypeError at key %q", err, "creation date")
+	}
+}
+
+func TestUnmarshalPointerSlices(t *testing.T) {
+	t.Run("Ints", func(t *testing.T) {
+		var got []*int
+		if err := Unmarshal([]byte("li1ei-2ee"), &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if len(got) != 2 || got[0] == nil || got[1] == nil || *got[0] != 1 || *got[1] != -2 {
+			t.Fatalf("Unmarshal() got = %v, want pointers to 1 and -2", got)
+		}
+		if got[0] == got[1] {
+			t.Error("Unmarshal() elements share a pointer")
+		}
+	})
+
+	t.Run("Strings", func(t *testing.T) {
+		var got []*string
+		if err := Unmarshal([]byte("l4:spam0:e"), &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if want := []*string{ptr("spam"), ptr("")}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal() got = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Structs", func(t *testing.T) {
+		type sub struct {
+			Name string `bencode:"name"`
+			Size int    `bencode:"size"`
+		}
+		var got []*sub
+		if err := Unmarshal([]byte("ld4:name1:a4:sizei1eed4:name1:bee"), &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if want := []*sub{{Name: "a", Size: 1}, {Name: "b"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal() got = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Field", func(t *testing.T) {
+		var got struct {
+			Tiers []*[]string `bencode:"tiers"`
+		}
+		if err := Unmarshal([]byte("d5:tiersll1:aeleee"), &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if want := []*[]string{{"a"}, {}}; !reflect.DeepEqual(got.Tiers, want) {
+			t.Errorf("Unmarshal() got = %v, want %v", got.Tiers, want)
+		}
+	})
+
+	t.Run("Type Error", func(t *testing.T) {
+		var got []*int
+		var typeErr *UnmarshalTypeError
+		if err := Unmarshal([]byte("li1e1:xe"), &got); !errors.As(err, &typeErr) {
+			t.Errorf("Unmarshal() error = %v, want *UnmarshalTypeError", err)
+		}
+	})
+}
+
+// announceEvent is an enumeration that is sent as a number, as in the UDP
+// tracker protocol, but used as text.
+type announceEvent string
+
+var announceEvents = []announceEvent{"none", "completed", "started", "stopped"}
+
+// announceEventHook decodes integers into an announceEvent by their index in
+// announceEvents, and base32 strings into []byte.
+func announceEventHook(target reflect.Type, raw any) (any, error) {
+	switch target {
+	case reflect.TypeFor[announceEvent]():
+		n, ok := raw.(int64)
+		if !ok {
+			return raw, nil
+		}
+		if n < 0 || n >= int64(len(announceEvents)) {
+			return nil, fmt.Errorf("unknown event %d", n)
+		}
+		return announceEvents[n], nil
+	case reflect.TypeFor[[]byte]():
+		s, ok := raw.(string)
+		if !ok {
+			return raw, nil
+		}
+		return base32.StdEncoding.DecodeString(s)
+	}
+	return raw, nil
+}
+
+func TestDecoderDecodeHook(t *testing.T) {
+	type announce struct {
+		Event    announceEvent   `bencode:"event"`
+		Events   []announceEvent `bencode:"events"`
+		InfoHash []byte          `bencode:"info_hash"`
+		Port     int             `bencode:"port"`
+		Extra    any             `bencode:"extra"`
+	}
+	in := "d5:eventi1e6:eventsli2e7:stoppede5:extral1:xe9:info_hash8:MFRGGZDF4:porti6881ee"
+
+	d := NewDecoder(strings.NewReader(in))
+	d.SetDecodeHook(announceEventHook)
+	var got announce
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := announce{
+		Event:    "completed",
+		Events:   []announceEvent{"started", "stopped"},
+		InfoHash: []byte("abcde"),
+		Port:     6881,
+		Extra:    []any{"x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %#v, want %#v", got, want)
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader("d6:eventsli9eee"))
+		d.SetDecodeHook(announceEventHook)
+		var got announce
+		err := d.Decode(&got)
+		if want := `bencode: decode hook for bencode.announceEvent at key "events": unknown event 9`; err == nil || err.Error() != want {
+			t.Errorf("Decode() error = %v, want %q", err, want)
+		}
+	})
+
+	t.Run("Converted Result", func(t *testing.T) {
+		// A result that is not of the target type is converted as usual.
+		d := NewDecoder(strings.NewReader("d4:porti1ee"))
+		d.SetDecodeHook(func(target reflect.Type, raw any) (any, error) {
+			if n, ok := raw.(int64); ok {
+				return n + 6880, nil
+			}
+			return raw, nil
+		})
+		var got announce
+		if err := d.Decode(&got); err != nil || got.Port != 6881 {
+			t.Errorf("Decode() got Port = %d, error = %v, want 6881", got.Port, err)
+		}
+	})
+
+	t.Run("Nil Result", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader("d4:porti1ee"))
+		d.SetDecodeHook(func(target reflect.Type, raw any) (any, error) {
+			if target.Kind() == reflect.Int {
+				return nil, nil
+			}
+			return raw, nil
+		})
+		got := announce{Port: 80}
+		if err := d.Decode(&got); err != nil || got.Port != 80 {
+			t.Errorf("Decode() got Port = %d, error = %v, want 80 unchanged", got.Port, err)
+		}
+	})
+}
+
+func TestUnmarshalInterfaceSliceIntegers(t *testing.T) {
+	// However a list reaches a []any, its integers have the type that
+	// decoding the same integer into an any gives, under each option.
+	const huge = "123456789012345678901234567890"
+	options := []struct {
+		name string
+		set  func(*Decoder)
+	}{
+		{name: "Default", set: func(*Decoder) {}},
+		{name: "UseInt", set: (*Decoder).UseInt},
+		{name: "UseNumber", set: (*Decoder).UseNumber},
+	}
+	targets := []struct {
+		name string
+		in   string
+		// elems decodes in and returns the elements of the []any within.
+		elems func(d *Decoder) ([]any, error)
+	}{
+		{name: "Slice", in: "li1ei-7ei" + huge + "ee", elems: func(d *Decoder) ([]any, error) {
+			var v []any
+			err := d.Decode(&v)
+			return v, err
+		}},
+		{name: "Existing Slice", in: "li1ei-7ei" + huge + "ee", elems: func(d *Decoder) ([]any, error) {
+			v := []any{"x", int64(0), 0}
+			err := d.Decode(&v)
+			return v, err
+		}},
+		{name: "Nested Slice", in: "lli1ei-7ei" + huge + "eee", elems: func(d *Decoder) ([]any, error) {
+			var v [][]any
+			err := d.Decode(&v)
+			if len(v) != 1 {
+				return nil, err
+			}
+			return v[0], err
+		}},
+		{name: "Map Value", in: "d1:ali1ei-7ei" + huge + "eee", elems: func(d *Decoder) ([]any, error) {
+			var v map[string][]any
+			err := d.Decode(&v)
+			return v["a"], err
+		}},
+		{name: "Struct Field", in: "d1:ali1ei-7ei" + huge + "eee", elems: func(d *Decoder) ([]any, error) {
+			var v struct {
+				A []any `bencode:"a"`
+			}
+			err := d.Decode(&v)
+			return v.A, err
+		}},
+		{name: "Interface", in: "li1ei-7ei" + huge + "ee", elems: func(d *Decoder) ([]any, error) {
+			var v any
+			err := d.Decode(&v)
+			list, _ := v.([]any)
+			return list, err
+		}},
+	}
+
+	for _, opt := range options {
+		// The reference is each integer decoded alone into an any.
+		var want []any
+		for _, in := range []string{"i1e", "i-7e", "i" + huge + "e"} {
+			d := NewDecoder(strings.NewReader(in))
+			opt.set(d)
+			var v any
+			if err := d.Decode(&v); err != nil {
+				t.Fatalf("%s: Decode(%q) error = %v", opt.name, in, err)
+			}
+			want = append(want, v)
+		}
+
+		for _, target := range targets {
+			t.Run(opt.name+"/"+target.name, func(t *testing.T) {
+				d := NewDecoder(strings.NewReader(target.in))
+				opt.set(d)
+				got, err := target.elems(d)
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Decode() got = %#v, want %#v", got, want)
+				}
+				for i := range min(len(got), len(want)) {
+					if reflect.TypeOf(got[i]) != reflect.TypeOf(want[i]) {
+						t.Errorf("element %d is %T, want %T", i, got[i], want[i])
+					}
+				}
+			})
+		}
+	}
+
+	// The default is int64, as the package documents.
+	var got []any
+	if err := Unmarshal([]byte("li1ee"), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := []any{int64(1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal() got = %#v, want %#v", got, want)
+	}
+}